@@ -0,0 +1,124 @@
+package nginxhealthz
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// HostCache is a read-through cache for GetStatsForHost. Successful
+// results are cached for the positive TTL and errors, such as a host
+// that does not exist, for the negative TTL, so that probes for
+// misconfigured hosts do not hit the NGINX API on every request.
+// It is safe for concurrent use.
+type HostCache struct {
+	fetch       func(ctx context.Context, host string) (Stats, error)
+	ttl         time.Duration
+	negativeTTL time.Duration
+	clock       Clock
+
+	mu      sync.Mutex
+	closed  bool
+	entries map[string]hostCacheEntry
+}
+
+type hostCacheEntry struct {
+	stats   Stats
+	err     error
+	expires time.Time
+}
+
+// NewHostCache returns a cache in front of c. A zero TTL disables
+// caching of the corresponding results.
+func NewHostCache(c *Client, ttl, negativeTTL time.Duration) *HostCache {
+	return newHostCache(c.GetStatsForHost, ttl, negativeTTL, c.clock)
+}
+
+func newHostCache(fetch func(context.Context, string) (Stats, error), ttl, negativeTTL time.Duration, clock Clock) *HostCache {
+	return &HostCache{
+		fetch:       fetch,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		clock:       clock,
+		entries:     make(map[string]hostCacheEntry),
+	}
+}
+
+// GetStatsForHost returns the cached result for the host, fetching it
+// when there is no unexpired entry.
+func (hc *HostCache) GetStatsForHost(ctx context.Context, host string) (Stats, error) {
+	now := hc.clock.Now()
+	hc.mu.Lock()
+	e, ok := hc.entries[host]
+	hc.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.stats, e.err
+	}
+
+	stats, err := hc.fetch(ctx, host)
+	ttl := hc.ttl
+	if err != nil {
+		ttl = hc.negativeTTL
+		// The caller gave up, which says nothing about the host.
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			ttl = 0
+		}
+	}
+	if ttl > 0 {
+		hc.mu.Lock()
+		if !hc.closed {
+			hc.entries[host] = hostCacheEntry{stats: stats, err: err, expires: now.Add(ttl)}
+		}
+		hc.mu.Unlock()
+	}
+	return stats, err
+}
+
+// Close drops all cached entries. Later calls to GetStatsForHost
+// go straight to the client.
+func (hc *HostCache) Close() error {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.closed = true
+	hc.entries = make(map[string]hostCacheEntry)
+	return nil
+}
+
+// WithCache makes GetStatsFor cache the stats of each upstream for the
+// TTL. Calls within the TTL return the cached stats, and the first call
+// after it fetches fresh ones. Errors are not cached. Upstream sets
+// fetched in a single call, as set with WithBatchThreshold, share the
+// same cache.
+func WithCache(ttl time.Duration) option {
+	return func(c *Client) error {
+		if ttl <= 0 {
+			return errors.New("cache TTL must be positive")
+		}
+		c.statsCacheTTL = ttl
+		c.statsCache = make(map[string]statsCacheEntry)
+		return nil
+	}
+}
+
+type statsCacheEntry struct {
+	stats     Stats
+	fetchedAt time.Time
+}
+
+// cachedStats returns the cached stats of the upstream, if fresh.
+func (c *Client) cachedStats(upstream string) (statsCacheEntry, bool) {
+	c.statsCacheMu.Lock()
+	defer c.statsCacheMu.Unlock()
+	e, ok := c.statsCache[upstream]
+	if !ok || c.clock.Now().Sub(e.fetchedAt) >= c.statsCacheTTL {
+		return statsCacheEntry{}, false
+	}
+	return e, true
+}
+
+func (c *Client) cacheStats(upstream string, stats Stats, fetchedAt time.Time) {
+	c.statsCacheMu.Lock()
+	defer c.statsCacheMu.Unlock()
+	c.statsCache[upstream] = statsCacheEntry{stats: stats, fetchedAt: fetchedAt}
+}
@@ -0,0 +1,244 @@
+package nginxhealthz_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+func newCountingTestNGINX(calls *int64, t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/http/upstreams") {
+			atomic.AddInt64(calls, 1)
+			io.WriteString(w, validResponseGetUpstreamsZones)
+			return
+		}
+		io.WriteString(w, validResponseUpstreamHGbackend)
+	}))
+}
+
+func TestHostCache_CachesSuccessfulResults(t *testing.T) {
+	t.Parallel()
+
+	var calls int64
+	ts := newCountingTestNGINX(&calls, t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc := nginxhealthz.NewHostCache(c, time.Hour, time.Hour)
+	defer hc.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := hc.GetStatsForHost(context.Background(), "bar.example.org"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt64(&calls); n != 1 {
+		t.Errorf("want 1 host lookup, got %d", n)
+	}
+}
+
+func TestHostCache_CachesErrorsForNegativeTTL(t *testing.T) {
+	t.Parallel()
+
+	var calls int64
+	ts := newCountingTestNGINX(&calls, t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc := nginxhealthz.NewHostCache(c, 0, time.Hour)
+	defer hc.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := hc.GetStatsForHost(context.Background(), "missing.example.org"); err == nil {
+			t.Fatal("want error for unknown host")
+		}
+	}
+	if n := atomic.LoadInt64(&calls); n != 1 {
+		t.Errorf("want 1 host lookup, got %d", n)
+	}
+
+	// Positive results are not cached with a zero TTL.
+	for i := 0; i < 2; i++ {
+		if _, err := hc.GetStatsForHost(context.Background(), "bar.example.org"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt64(&calls); n != 3 {
+		t.Errorf("want 3 host lookups, got %d", n)
+	}
+}
+
+func TestHostCache_CloseClearsEntries(t *testing.T) {
+	t.Parallel()
+
+	var calls int64
+	ts := newCountingTestNGINX(&calls, t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc := nginxhealthz.NewHostCache(c, time.Hour, time.Hour)
+	if _, err := hc.GetStatsForHost(context.Background(), "bar.example.org"); err != nil {
+		t.Fatal(err)
+	}
+	if err := hc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hc.GetStatsForHost(context.Background(), "bar.example.org"); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&calls); n != 2 {
+		t.Errorf("want 2 host lookups, got %d", n)
+	}
+}
+
+func TestWithCache_ReturnsCachedStatsWithinTTL(t *testing.T) {
+	t.Parallel()
+
+	var calls int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		io.WriteString(w, validResponseUpstreamHGbackend)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithCache(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStatsForUpstreams(context.Background(), []string{"hg-backend", "hg-backend", "hg-backend"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetStatsFor(context.Background(), "hg-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.Stats{Total: 2, Up: 1, Down: 1}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	// Concurrent calls racing on an empty cache may each fetch,
+	// but the call after them must be served from the cache.
+	if n := atomic.LoadInt64(&calls); n > 3 {
+		t.Errorf("want at most 3 API calls, got %d", n)
+	}
+	before := atomic.LoadInt64(&calls)
+	c.GetStatsFor(context.Background(), "hg-backend")
+	if n := atomic.LoadInt64(&calls); n != before {
+		t.Errorf("want cached stats, got %d new API calls", n-before)
+	}
+}
+
+func TestWithCache_FetchesFreshStatsAfterTTL(t *testing.T) {
+	t.Parallel()
+
+	var calls int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		io.WriteString(w, validResponseUpstreamHGbackend)
+	}))
+	defer ts.Close()
+
+	clock := newFakeClock()
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithCache(10*time.Millisecond), nginxhealthz.WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStatsFor(context.Background(), "hg-backend"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(20 * time.Millisecond)
+	if _, err := c.GetStatsFor(context.Background(), "hg-backend"); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&calls); n != 2 {
+		t.Errorf("want 2 API calls, got %d", n)
+	}
+}
+
+func TestWithCache_ServesBatchedUpstreamsFromCache(t *testing.T) {
+	t.Parallel()
+
+	var calls int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		verifyURIs("/api/8/http/upstreams?fields=peers", r.RequestURI, t)
+		io.WriteString(w, validResponseGetAllUpstreams)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithCache(time.Minute), nginxhealthz.WithBatchThreshold(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstreams := []string{"hg-backend", "lxr-backend"}
+	first, err := c.GetStatsForUpstreams(context.Background(), upstreams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.GetStatsForUpstreams(context.Background(), upstreams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&calls); n != 1 {
+		t.Errorf("want 1 API call, got %d", n)
+	}
+	if !cmp.Equal(first, second) {
+		t.Error(cmp.Diff(first, second))
+	}
+}
+
+func TestGetStatsSnapshotFor_KeepsCollectionTimeOfCachedStats(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestNGINX(t)
+	defer ts.Close()
+
+	clock := newFakeClock()
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithCache(time.Hour), nginxhealthz.WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := c.GetStatsSnapshotFor(context.Background(), "hg-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(10 * time.Millisecond)
+	second, err := c.GetStatsSnapshotFor(context.Background(), "hg-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.CollectedAt.Equal(first.CollectedAt) {
+		t.Errorf("want cached collection time %v, got %v", first.CollectedAt, second.CollectedAt)
+	}
+	if got := second.Age(); got != 10*time.Millisecond {
+		t.Errorf("want age of 10ms, got %v", got)
+	}
+}
+
+func TestWithCache_FailsOnNonPositiveTTL(t *testing.T) {
+	t.Parallel()
+
+	if _, err := nginxhealthz.NewClient("http://localhost:9001", nginxhealthz.WithCache(0)); err == nil {
+		t.Fatal("want error")
+	}
+}
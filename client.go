@@ -1,16 +1,31 @@
 package nginxhealthz
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
 )
 
 type responseUpstream struct {
@@ -31,20 +46,13 @@ type responseUpstream struct {
 		HeaderTime   int `json:"header_time"`
 		ResponseTime int `json:"response_time"`
 		Responses    struct {
-			OneXx   int `json:"1xx"`
-			TwoXx   int `json:"2xx"`
-			ThreeXx int `json:"3xx"`
-			FourXx  int `json:"4xx"`
-			FiveXx  int `json:"5xx"`
-			Codes   struct {
-				Num200 int `json:"200"`
-				Num301 int `json:"301"`
-				Num304 int `json:"304"`
-				Num400 int `json:"400"`
-				Num404 int `json:"404"`
-				Num405 int `json:"405"`
-			} `json:"codes"`
-			Total int `json:"total"`
+			OneXx   int            `json:"1xx"`
+			TwoXx   int            `json:"2xx"`
+			ThreeXx int            `json:"3xx"`
+			FourXx  int            `json:"4xx"`
+			FiveXx  int            `json:"5xx"`
+			Codes   map[string]int `json:"codes"`
+			Total   int            `json:"total"`
 		} `json:"responses"`
 		Sent         int64 `json:"sent"`
 		Received     int64 `json:"received"`
@@ -64,10 +72,93 @@ type responseUpstream struct {
 	Zone      string `json:"zone"`
 }
 
+// Stats holds the number of peers in an upstream in each state.
+// Down counts only peers in the "down" state.
 type Stats struct {
-	Total int
-	Up    int
-	Down  int
+	Total     int `json:"total"`
+	Up        int `json:"up"`
+	Down      int `json:"down"`
+	Unavail   int `json:"unavail"`
+	Draining  int `json:"draining"`
+	Checking  int `json:"checking"`
+	Unhealthy int `json:"unhealthy"`
+	// Backup is the number of backup peers, which are also counted in
+	// Total and by state unless WithBackupExcluded is used.
+	Backup int `json:"backup"`
+	// Unknown is the number of peers in an empty or unrecognised
+	// state, as NGINX may briefly report during a reload.
+	Unknown int `json:"unknown"`
+	// Empty is the number of summed upstreams that have no peers.
+	// The stats of a single upstream never count it, as getting
+	// them fails with ErrNoPeers instead.
+	Empty int `json:"empty"`
+}
+
+func (s Stats) add(o Stats) Stats {
+	return Stats{
+		Total:     s.Total + o.Total,
+		Up:        s.Up + o.Up,
+		Down:      s.Down + o.Down,
+		Unavail:   s.Unavail + o.Unavail,
+		Draining:  s.Draining + o.Draining,
+		Checking:  s.Checking + o.Checking,
+		Unhealthy: s.Unhealthy + o.Unhealthy,
+		Backup:    s.Backup + o.Backup,
+		Unknown:   s.Unknown + o.Unknown,
+		Empty:     s.Empty + o.Empty,
+	}
+}
+
+// UpFraction returns the fraction of peers that are up,
+// or zero when there are no peers.
+func (s Stats) UpFraction() float64 {
+	if s.Total == 0 {
+		return 0
+	}
+	return float64(s.Up) / float64(s.Total)
+}
+
+// DownFraction returns the fraction of peers that are not up, in any
+// other state, or zero when there are no peers.
+func (s Stats) DownFraction() float64 {
+	if s.Total == 0 {
+		return 0
+	}
+	return float64(s.Total-s.Up) / float64(s.Total)
+}
+
+// Healthy reports whether there are peers, no upstream is empty and at
+// most the fraction maxDown, between 0 and 1, of the peers are not up.
+// Healthy(0) requires all peers to be up.
+func (s Stats) Healthy(maxDown float64) bool {
+	return s.Total > 0 && s.Empty == 0 && s.DownFraction() <= maxDown
+}
+
+// Peer represents a single server in an upstream.
+type Peer struct {
+	ID       int
+	Server   string
+	Name     string
+	Backup   bool
+	Weight   int
+	State    string
+	Active   int
+	Requests int
+	Fails    int
+	Unavail  int
+	Downtime time.Duration
+	Selected time.Time
+
+	// HeaderTime and ResponseTime are the average times
+	// to get the response header and the full response.
+	HeaderTime   time.Duration
+	ResponseTime time.Duration
+}
+
+// HostPort splits the server address of the peer into host and port.
+// IPv6 hosts are returned without brackets.
+func (p Peer) HostPort() (host, port string, err error) {
+	return net.SplitHostPort(p.Server)
 }
 
 type option func(*Client) error
@@ -82,172 +173,2027 @@ func WithHTTPClient(h *http.Client) option {
 	}
 }
 
+// defaultTimeout bounds requests sent by the default HTTP client.
+const defaultTimeout = 10 * time.Second
+
+// normalizeBaseURL checks that baseURL is an absolute URL with
+// a scheme and host, and strips trailing slashes.
+func normalizeBaseURL(baseURL string) (string, error) {
+	if baseURL == "" {
+		return "", errors.New("invalid base URL: empty")
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid base URL %q: %w", baseURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid base URL %q: want http or https scheme", baseURL)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid base URL %q: missing host", baseURL)
+	}
+	return strings.TrimRight(u.String(), "/"), nil
+}
+
+// WithUserAgent sets the User-Agent header sent with every request to the
+// NGINX API. It defaults to "nginx-healthz/<module version>".
+func WithUserAgent(ua string) option {
+	return func(c *Client) error {
+		if ua == "" {
+			return errors.New("empty user agent")
+		}
+		c.userAgent = ua
+		return nil
+	}
+}
+
+// defaultUserAgent returns the user agent naming the module version
+// the binary was built with.
+func defaultUserAgent() string {
+	version := "devel"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, m := range append([]*debug.Module{&info.Main}, info.Deps...) {
+			if m.Path == "github.com/qba73/nginx-healthz" && m.Version != "" && m.Version != "(devel)" {
+				version = m.Version
+			}
+		}
+	}
+	return "nginx-healthz/" + version
+}
+
+// WithTimeout sets the timeout of the default HTTP client, which
+// defaults to 10 seconds. The context passed to each call still
+// applies on top of it. It has no effect together with WithHTTPClient,
+// as the provided client wins over the default.
+func WithTimeout(d time.Duration) option {
+	return func(c *Client) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid timeout: %v", d)
+		}
+		c.timeout = d
+		return nil
+	}
+}
+
+// WithDefaultRequestTimeout bounds every API call made with a context
+// without deadline, such as context.Background(), to d, including its
+// retries. Contexts with a deadline are left as they are. Unlike
+// WithTimeout, which bounds each single HTTP request of the default
+// client, it also applies with WithHTTPClient.
+func WithDefaultRequestTimeout(d time.Duration) option {
+	return func(c *Client) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid default request timeout: %v", d)
+		}
+		c.defaultRequestTimeout = d
+		return nil
+	}
+}
+
+// minVersion is the lowest supported NGINX Plus API version.
+const minVersion = 4
+
+// WithAPIPath sets the path prefix the NGINX API is served under, for
+// example "/nginx-api" when a reverse proxy rewrites paths. The prefix
+// must begin with "/". The default is "/api".
+func WithAPIPath(prefix string) option {
+	return func(c *Client) error {
+		if !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("invalid API path %q: must begin with /", prefix)
+		}
+		c.apiPath = strings.TrimRight(prefix, "/")
+		return nil
+	}
+}
+
+// WithVersion sets the NGINX Plus API version, which must be 4 or newer.
 func WithVersion(v int) option {
 	return func(c *Client) error {
-		switch v {
-		case 4, 5, 6, 7, 8:
-			c.version = v
-			return nil
-		default:
+		if v < minVersion {
 			return fmt.Errorf("unsupported NGINX version: %d", v)
 		}
+		c.version = v
+		return nil
 	}
 }
 
-type Client struct {
-	version    int
-	baseURL    string
-	httpClient *http.Client
+// WithAutoVersion makes the client discover the API version with
+// DiscoverVersion before its first request. When discovery fails the
+// client keeps the version set by WithVersion, or the default.
+func WithAutoVersion() option {
+	return func(c *Client) error {
+		c.autoVersion = true
+		return nil
+	}
 }
 
-func NewClient(baseURL string, opts ...option) (*Client, error) {
-	if baseURL == "" {
-		return nil, errors.New("invalid base URL")
+// WithLatestVersion makes NewClient query the API root for the versions
+// supported by the NGINX instance and use the highest one. NewClient
+// fails when the query fails.
+func WithLatestVersion() option {
+	return func(c *Client) error {
+		c.latestVersion = true
+		return nil
 	}
+}
 
-	c := Client{
-		version:    8,
-		baseURL:    baseURL,
-		httpClient: &http.Client{},
+// WithVersionFallback makes the client probe lower API versions when
+// the configured version path returns 404, and switch to the highest
+// version the NGINX instance responds to.
+func WithVersionFallback() option {
+	return func(c *Client) error {
+		c.versionFallback = true
+		return nil
 	}
+}
 
-	for _, opt := range opts {
-		if err := opt(&c); err != nil {
-			return nil, err
+// WithLogger sets the logger used by the client.
+func WithLogger(l *slog.Logger) option {
+	return func(c *Client) error {
+		if l == nil {
+			return errors.New("nil logger")
 		}
+		c.logger = l
+		return nil
 	}
-	return &c, nil
 }
 
-func (c *Client) GetStatsFor(ctx context.Context, upstream string) (Stats, error) {
-	url := fmt.Sprintf("%s/api/%d/http/upstreams/%s", c.baseURL, c.version, upstream)
-	var res responseUpstream
-	if err := c.get(ctx, url, &res); err != nil {
-		return Stats{}, err
+// WithPeerEnricher registers a function called for every peer before it
+// is returned to the caller, for example to resolve the server address or
+// attach deployment metadata. Enrichers run in the order they were
+// registered, once per peer, on the goroutine building the result. They may
+// be called concurrently for different results and must be safe for
+// concurrent use.
+func WithPeerEnricher(fn func(*Peer)) option {
+	return func(c *Client) error {
+		if fn == nil {
+			return errors.New("nil peer enricher")
+		}
+		c.peerEnrichers = append(c.peerEnrichers, fn)
+		return nil
 	}
-	return calculateStatsFor(upstream, res)
 }
 
-func calculateStatsFor(upstream string, res responseUpstream) (Stats, error) {
-	if len(res.Peers) < 1 {
-		return Stats{}, errors.New("no servers in upstream")
+// WithBackupExcluded makes stats count backup peers only in Stats.Backup,
+// leaving them out of Total and the state counts, so that idle backup
+// servers do not inflate the capacity of an upstream.
+func WithBackupExcluded() option {
+	return func(c *Client) error {
+		c.backupExcluded = true
+		return nil
 	}
+}
 
-	total := len(res.Peers)
-	up := 0
+// WithDrainingCountsAsUp makes the client treat draining peers as up:
+// stats count them as Up instead of Draining, and health checks, scores,
+// weights and down peer lists no longer report them. Draining peers still
+// serve existing connections, so a rolling deploy that drains them does
+// not fail health checks.
+func WithDrainingCountsAsUp() option {
+	return func(c *Client) error {
+		c.drainingAsUp = true
+		return nil
+	}
+}
 
-	for _, p := range res.Peers {
-		if p.State == "up" {
-			up++
+// WithIdlePeersExcluded makes GetLatencyStatsFor leave out peers that
+// served no requests, whose zero times would skew the stats.
+func WithIdlePeersExcluded() option {
+	return func(c *Client) error {
+		c.idlePeersExcluded = true
+		return nil
+	}
+}
+
+// WithZoneParser sets the function extracting the hostname from the zone
+// name of an upstream, for zones not named "<hostname>-<upstream>". The
+// parser returns an empty string for zones that name no host.
+func WithZoneParser(parse func(zone string) (host string)) option {
+	return func(c *Client) error {
+		if parse == nil {
+			return errors.New("nil zone parser")
 		}
+		c.zoneParser = parse
+		return nil
 	}
-	down := total - up
-	return Stats{Total: total, Up: up, Down: down}, nil
 }
 
-func (c *Client) GetUpstreamsFor(ctx context.Context, hostname string) (map[string][]string, error) {
-	url := fmt.Sprintf("%s/api/%d/http/upstreams?fields=zone", c.baseURL, c.version)
+// WithMissingAsZero makes GetStatsFor return zero stats instead of an
+// error when the upstream does not exist (the API responds with 404),
+// for example while the upstream is still being provisioned.
+func WithMissingAsZero() option {
+	return func(c *Client) error {
+		c.missingAsZero = true
+		return nil
+	}
+}
 
-	var response interface{}
-	err := c.get(ctx, url, &response)
-	if err != nil {
-		return nil, fmt.Errorf("retrieving zones: %w", err)
+// WithProtocol selects whether the client reads HTTP or stream (TCP/UDP)
+// upstreams. Methods taking an upstream name, such as GetStatsFor,
+// methods reading all upstreams, such as GetAllStats, and methods
+// discovering the upstreams of a host, such as IsHostHealthy,
+// GetDownPeersForHost and NewHostCollector, use it. GetUpstreamsFor and
+// GetStreamUpstreamsFor always read their own protocol, and
+// GetStatsForHost reads both. The default is ProtocolHTTP.
+func WithProtocol(p Protocol) option {
+	return func(c *Client) error {
+		if p != ProtocolHTTP && p != ProtocolStream {
+			return fmt.Errorf("unsupported protocol: %d", p)
+		}
+		c.protocol = p
+		return nil
 	}
-	return hostnameUpstreamsFromResponse(hostname, response), nil
 }
 
-func hostnameUpstreamsFromResponse(hostname string, res interface{}) map[string][]string {
-	hostUpstreams := make(map[string][]string)
-	m := res.(map[string]interface{})
+// WithBatchThreshold sets the number of upstreams from which
+// GetResultsForUpstreams and GetStatsForUpstreams fetch all upstreams in a
+// single API call instead of one call per upstream. Zero disables batching.
+// The default is 20.
+func WithBatchThreshold(n int) option {
+	return func(c *Client) error {
+		if n < 0 {
+			return fmt.Errorf("invalid batch threshold: %d", n)
+		}
+		c.batchThreshold = n
+		return nil
+	}
+}
 
-	for u, v := range m {
-		value, ok := v.(map[string]interface{})
-		if !ok {
-			continue
+// WithMaxConcurrency limits the number of upstreams GetResultsForUpstreams
+// and GetStatsForUpstreams query at the same time. Zero selects the
+// default of four times the number of CPUs.
+func WithMaxConcurrency(n int) option {
+	return func(c *Client) error {
+		if n < 0 {
+			return fmt.Errorf("invalid max concurrency: %d", n)
 		}
-		z := value["zone"]
-		host, ok := z.(string)
-		if !ok {
-			continue
+		if n == 0 {
+			n = defaultMaxConcurrency()
 		}
+		c.maxConcurrency = n
+		return nil
+	}
+}
 
-		// We need to got from this: "bar.example.org-lxr-backend"
-		// to this: "bar.example.org", which is the hostname we
-		// are looking for.
-		host = strings.Split(host, "-")[0]
-		if host != hostname {
-			continue
+// WithFailFast makes GetResultsForUpstreams and GetStatsForUpstreams
+// cancel the requests still outstanding as soon as one upstream fails.
+// The upstreams not queried report the cancellation as their error.
+func WithFailFast() option {
+	return func(c *Client) error {
+		c.failFast = true
+		return nil
+	}
+}
+
+func defaultMaxConcurrency() int {
+	return runtime.NumCPU() * 4
+}
+
+// PeerIdentity selects the peer field used to identify peers in results
+// keyed by peer, such as GetSSLReuseRatioFor or GetPeersByDowntime.
+type PeerIdentity int
+
+const (
+	// PeerIdentityServer identifies peers by their address, the "server"
+	// field of the API. Peers configured with a hostname that resolves to
+	// several addresses each have their own address. This is the default.
+	PeerIdentityServer PeerIdentity = iota
+	// PeerIdentityName identifies peers by the "name" field of the API,
+	// the server as written in the NGINX configuration. It can be a
+	// hostname shared by several peers.
+	PeerIdentityName
+)
+
+// WithPeerIdentity sets the field identifying peers in results.
+func WithPeerIdentity(id PeerIdentity) option {
+	return func(c *Client) error {
+		switch id {
+		case PeerIdentityServer, PeerIdentityName:
+			c.peerIdentity = id
+			return nil
+		default:
+			return fmt.Errorf("unsupported peer identity: %d", id)
 		}
-		// Checking is we have already host -> upstreams mapping in place
-		// if yes we need to add upstream to the slice, if not
-		// we create map: hostname => [upstream1, upstream2, upstreamN]
-		// and keep adding upstreams as we loop through m.
-		_, ok = hostUpstreams[host]
-		if !ok {
-			hostUpstreams[host] = []string{u}
-			continue
+	}
+}
+
+// peerID returns the identity of the peer with the given server and name.
+func (c *Client) peerID(server, name string) string {
+	return c.peerIdentity.of(server, name)
+}
+
+// of returns the field of the peer with the given server and name
+// selected by the identity.
+func (id PeerIdentity) of(server, name string) string {
+	if id == PeerIdentityName {
+		return name
+	}
+	return server
+}
+
+// WithRetries makes the client retry GET requests failing with a
+// connection error or a 5xx response up to n times. The first retry
+// waits baseDelay, and the delay doubles with every further retry.
+func WithRetries(n int, baseDelay time.Duration) option {
+	return func(c *Client) error {
+		if n < 0 {
+			return fmt.Errorf("invalid number of retries: %d", n)
 		}
-		hostUpstreams[host] = append(hostUpstreams[host], u)
+		if baseDelay < 0 {
+			return fmt.Errorf("invalid retry delay: %v", baseDelay)
+		}
+		c.retries = n
+		c.retryBaseDelay = baseDelay
+		return nil
 	}
-	return hostUpstreams
 }
 
-func (c *Client) GetStatsForHost(ctx context.Context, hostname string) (Stats, error) {
-	upstreams, err := c.GetUpstreamsFor(ctx, hostname)
+// WithBasicAuth makes the client authenticate requests to the NGINX API
+// with HTTP basic auth.
+func WithBasicAuth(user, pass string) option {
+	return func(c *Client) error {
+		if user == "" {
+			return errors.New("missing basic auth user")
+		}
+		c.basicAuth = &basicAuth{user: user, pass: pass}
+		return nil
+	}
+}
+
+// WithBearerToken makes the client send the token in the
+// Authorization header of every request to the NGINX API.
+func WithBearerToken(token string) option {
+	return func(c *Client) error {
+		if token == "" {
+			return errors.New("missing bearer token")
+		}
+		return WithHeader("Authorization", "Bearer "+token)(c)
+	}
+}
+
+// WithHeader makes the client send the header with every request to
+// the NGINX API, for example an API key expected by a gateway. It can
+// be used multiple times to set several headers.
+func WithHeader(key, value string) option {
+	return func(c *Client) error {
+		if key == "" {
+			return errors.New("missing header name")
+		}
+		if c.headers == nil {
+			c.headers = make(map[string]string)
+		}
+		c.headers[http.CanonicalHeaderKey(key)] = value
+		return nil
+	}
+}
+
+type basicAuth struct {
+	user, pass string
+}
+
+type Client struct {
+	baseURL    string
+	apiPath    string
+	httpClient *http.Client
+	timeout    time.Duration
+	tlsConfig  *tls.Config
+	userAgent  string
+	logger     *slog.Logger
+	tracer     trace.Tracer
+
+	retries        int
+	retryBaseDelay time.Duration
+
+	defaultRequestTimeout time.Duration
+	maxIdleConnsPerHost   int
+	idleConnTimeout       time.Duration
+	protocol              Protocol
+	failFast              bool
+
+	// inflight collapses concurrent identical GET requests, and
+	// sharedCalls tracks the callers waiting for each of them.
+	inflight    singleflight.Group
+	sharedMu    sync.Mutex
+	sharedCalls map[string]*sharedCall
+	// sharedJoined, when set by tests, is called once a caller
+	// of sharedGet waits for the shared request.
+	sharedJoined func()
+	basicAuth    *basicAuth
+	headers      map[string]string
+
+	mu            sync.RWMutex
+	version       int
+	latestVersion bool
+	autoVersion   bool
+	autoOnce      sync.Once
+
+	versionFallback bool
+	fallbackMu      sync.Mutex
+
+	peerEnrichers  []func(*Peer)
+	missingAsZero  bool
+	backupExcluded bool
+	drainingAsUp   bool
+
+	idlePeersExcluded bool
+	zoneParser        func(zone string) string
+	peerIdentity      PeerIdentity
+	dryRun            bool
+	batchThreshold    int
+	maxConcurrency    int
+
+	generationMu sync.Mutex
+	generation   int64
+
+	scoreWeights HealthScoreWeights
+
+	snapshotPath    string
+	snapshotMu      sync.Mutex
+	snapshot        map[string]snapshotEntry
+	snapshotSavedAt time.Time
+	snapshotSeq     int64
+	snapshotWriteMu sync.Mutex
+	snapshotWritten int64
+
+	metrics clientMetrics
+
+	statsCacheTTL time.Duration
+	statsCacheMu  sync.Mutex
+	statsCache    map[string]statsCacheEntry
+
+	clock Clock
+}
+
+func NewClient(baseURL string, opts ...option) (*Client, error) {
+	baseURL, err := normalizeBaseURL(baseURL)
 	if err != nil {
-		return Stats{}, fmt.Errorf("getting stats for host %s: %w", hostname, err)
+		return nil, err
 	}
-	ux, ok := upstreams[hostname]
-	if !ok {
-		return Stats{}, fmt.Errorf("no stat data for host %s", hostname)
+
+	c := Client{
+		version: 8,
+		baseURL: baseURL,
+		apiPath: "/api",
+		timeout: defaultTimeout,
+
+		userAgent: defaultUserAgent(),
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		tracer:    defaultTracer(),
+
+		scoreWeights:   defaultHealthScoreWeights,
+		batchThreshold: 20,
+		maxConcurrency: defaultMaxConcurrency(),
+		snapshot:       make(map[string]snapshotEntry),
+		clock:          realClock{},
+	}
+
+	for _, opt := range opts {
+		if err := opt(&c); err != nil {
+			return nil, err
+		}
+	}
+	if c.httpClient == nil {
+		c.httpClient = c.defaultHTTPClient()
+	} else if c.transportConfigured() {
+		return nil, errTransportWithHTTPClient
+	}
+	if c.latestVersion {
+		v, err := c.DiscoverVersion(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("discovering NGINX API version: %w", err)
+		}
+		c.version = v
 	}
-	return c.GetStatsForUpstreams(ctx, ux), nil
+	if c.snapshotPath != "" {
+		c.loadSnapshot()
+	}
+	return &c, nil
 }
 
-func (c *Client) GetStatsForUpstreams(ctx context.Context, upstreams []string) Stats {
-	var total, up, down uint64
+// Version returns the NGINX API version the client uses.
+func (c *Client) Version() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.version
+}
 
-	var wg sync.WaitGroup
-	wg.Add(len(upstreams))
+func (c *Client) GetStatsFor(ctx context.Context, upstream string) (Stats, error) {
+	snap, err := c.GetStatsSnapshotFor(ctx, upstream)
+	if err != nil {
+		return Stats{}, err
+	}
+	return snap.Stats, nil
+}
 
-	for _, u := range upstreams {
-		go func(upstream string) {
-			defer wg.Done()
-			stat, err := c.GetStatsFor(ctx, upstream)
-			if err != nil {
-				return
-			}
-			atomic.AddUint64(&total, uint64(stat.Total))
-			atomic.AddUint64(&up, uint64(stat.Up))
-			atomic.AddUint64(&down, uint64(stat.Down))
-		}(u)
+// WeightedStats holds the stats of an upstream together with the
+// summed weights of its peers, for upstreams whose peers do not take
+// an equal share of the traffic.
+type WeightedStats struct {
+	Stats
+	// WeightUp is the summed weight of the peers that are up and
+	// WeightTotal that of all peers counted in Total.
+	WeightUp    int `json:"weight_up"`
+	WeightTotal int `json:"weight_total"`
+}
+
+// WeightUpFraction returns the fraction of the total weight
+// carried by peers that are up, or zero without peers.
+func (s WeightedStats) WeightUpFraction() float64 {
+	if s.WeightTotal == 0 {
+		return 0
 	}
-	wg.Wait()
-	return Stats{Total: int(total), Up: int(up), Down: int(down)}
+	return float64(s.WeightUp) / float64(s.WeightTotal)
 }
 
-func (c *Client) get(ctx context.Context, url string, data interface{}) error {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+// GetWeightedStatsFor works like GetStatsFor and also sums the weights
+// of the peers of the upstream. Backup peers excluded with
+// WithBackupExcluded do not count towards the weights.
+func (c *Client) GetWeightedStatsFor(ctx context.Context, upstream string) (WeightedStats, error) {
+	res, err := c.getUpstream(ctx, upstream)
 	if err != nil {
-		return fmt.Errorf("creating request: %w", err)
+		return WeightedStats{}, err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := c.httpClient.Do(req)
+	stats, err := c.calculateStatsFor(upstream, res)
 	if err != nil {
-		return fmt.Errorf("sending request: %w", err)
+		return WeightedStats{}, err
+	}
+	ws := WeightedStats{Stats: stats}
+	for _, p := range res.Peers {
+		if p.Backup && c.backupExcluded {
+			continue
+		}
+		ws.WeightTotal += p.Weight
+		if c.isUp(p.State) {
+			ws.WeightUp += p.Weight
+		}
 	}
-	defer resp.Body.Close()
+	return ws, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("got response code: %v", resp.StatusCode)
+// StatsSnapshot holds the stats of an upstream or host together
+// with the time they were collected.
+type StatsSnapshot struct {
+	// Source is the name of the upstream or host.
+	Source string `json:"source"`
+	Stats
+	CollectedAt time.Time `json:"collected_at"`
+
+	// clock is the clock of the client that collected the stats.
+	clock Clock
+}
+
+// Age returns how long ago the stats were collected, measured with the
+// clock of the client that collected them or, without one, the system
+// clock.
+func (s StatsSnapshot) Age() time.Duration {
+	if s.clock == nil {
+		return time.Since(s.CollectedAt)
+	}
+	return s.clock.Now().Sub(s.CollectedAt)
+}
+
+// GetStatsSnapshotFor works like GetStatsFor and also returns when the
+// stats were collected. Stats served from the cache set with WithCache
+// keep the time they were fetched from NGINX.
+func (c *Client) GetStatsSnapshotFor(ctx context.Context, upstream string) (StatsSnapshot, error) {
+	if c.statsCacheTTL > 0 {
+		if e, ok := c.cachedStats(upstream); ok {
+			return StatsSnapshot{Source: upstream, Stats: e.stats, CollectedAt: e.fetchedAt, clock: c.clock}, nil
+		}
+	}
+	fetchedAt := c.clock.Now()
+	res, err := c.getUpstreamFor(ctx, c.protocol.String(), upstream, statsQuery)
+	if err != nil {
+		if c.missingAsZero && isNotFound(err) {
+			return StatsSnapshot{Source: upstream, CollectedAt: fetchedAt, clock: c.clock}, nil
+		}
+		return StatsSnapshot{}, err
+	}
+	stats, err := c.calculateStatsFor(upstream, res)
+	if err != nil {
+		return StatsSnapshot{}, err
+	}
+	if c.statsCacheTTL > 0 {
+		c.cacheStats(upstream, stats, fetchedAt)
+	}
+	return StatsSnapshot{Source: upstream, Stats: stats, CollectedAt: fetchedAt, clock: c.clock}, nil
+}
+
+// GetPeersFor returns all peers configured in the upstream.
+func (c *Client) GetPeersFor(ctx context.Context, upstream string) ([]Peer, error) {
+	res, err := c.getUpstream(ctx, upstream)
+	if err != nil {
+		return nil, err
 	}
+	return c.peersFromResponse(res), nil
+}
 
-	body, err := io.ReadAll(resp.Body)
+// GetStatsAndPeersFor returns both the aggregated stats and the
+// peers of the upstream decoded from a single API call.
+func (c *Client) GetStatsAndPeersFor(ctx context.Context, upstream string) (Stats, []Peer, error) {
+	res, err := c.getUpstream(ctx, upstream)
 	if err != nil {
-		return fmt.Errorf("reading response body: %w", err)
+		return Stats{}, nil, err
 	}
-	if err := json.Unmarshal(body, data); err != nil {
-		return fmt.Errorf("unmarshaling response body: %w", err)
+	stats, err := c.calculateStatsFor(upstream, res)
+	if err != nil {
+		return Stats{}, nil, err
 	}
-	return nil
+	return stats, c.peersFromResponse(res), nil
+}
+
+// PeerHealth holds the result of NGINX active health checks for a peer.
+type PeerHealth struct {
+	// Server identifies the peer as set by WithPeerIdentity.
+	Server     string
+	Checks     int
+	Fails      int
+	Unhealthy  int
+	LastPassed bool
+}
+
+type responsePeersHealth struct {
+	Peers []struct {
+		Server       string `json:"server"`
+		Name         string `json:"name"`
+		HealthChecks struct {
+			Checks     int  `json:"checks"`
+			Fails      int  `json:"fails"`
+			Unhealthy  int  `json:"unhealthy"`
+			LastPassed bool `json:"last_passed"`
+		} `json:"health_checks"`
+	} `json:"peers"`
+}
+
+// GetHealthSummaryFor returns the active health check results of each
+// peer in the upstream. It asks NGINX only for the peers field and decodes
+// only the peer address and health check counters.
+func (c *Client) GetHealthSummaryFor(ctx context.Context, upstream string) ([]PeerHealth, error) {
+	path, err := upstreamPath(c.protocol.String(), upstream)
+	if err != nil {
+		return nil, err
+	}
+	var res responsePeersHealth
+	if err := c.getAPI(ctx, path+"?fields=peers", &res); err != nil {
+		return nil, err
+	}
+	health := make([]PeerHealth, 0, len(res.Peers))
+	for _, p := range res.Peers {
+		health = append(health, PeerHealth{
+			Server:     c.peerID(p.Server, p.Name),
+			Checks:     p.HealthChecks.Checks,
+			Fails:      p.HealthChecks.Fails,
+			Unhealthy:  p.HealthChecks.Unhealthy,
+			LastPassed: p.HealthChecks.LastPassed,
+		})
+	}
+	return health, nil
+}
+
+// HealthCheckStats holds the active health check results of an
+// upstream, summed across its peers.
+type HealthCheckStats struct {
+	Checks    int `json:"checks"`
+	Fails     int `json:"fails"`
+	Unhealthy int `json:"unhealthy"`
+	// Passing and Failing count the peers by the
+	// result of their last health check.
+	Passing int `json:"passing"`
+	Failing int `json:"failing"`
+	// LastPassed holds the result of the last health check of each
+	// peer, keyed by the peer as identified by WithPeerIdentity.
+	LastPassed map[string]bool `json:"last_passed"`
+}
+
+// GetHealthCheckStatsFor returns the health check results NGINX reports
+// for the upstream. Unlike the peer state used by GetStatsFor, they do
+// not lag behind the checks.
+func (c *Client) GetHealthCheckStatsFor(ctx context.Context, upstream string) (HealthCheckStats, error) {
+	health, err := c.GetHealthSummaryFor(ctx, upstream)
+	if err != nil {
+		return HealthCheckStats{}, err
+	}
+	stats := HealthCheckStats{LastPassed: make(map[string]bool, len(health))}
+	for _, h := range health {
+		stats.Checks += h.Checks
+		stats.Fails += h.Fails
+		stats.Unhealthy += h.Unhealthy
+		if h.LastPassed {
+			stats.Passing++
+		} else {
+			stats.Failing++
+		}
+		stats.LastPassed[h.Server] = h.LastPassed
+	}
+	return stats, nil
+}
+
+// Headroom returns how many more peers of the upstream can go down before
+// fewer than minUp peers are up. With minUp set to 1 it is the number of
+// peers that can fail before the upstream has no up peer left. The result
+// is negative when the upstream already has fewer than minUp peers up.
+func (c *Client) Headroom(ctx context.Context, upstream string, minUp int) (int, error) {
+	if minUp < 1 {
+		return 0, fmt.Errorf("invalid minimum number of up peers: %d", minUp)
+	}
+	stats, err := c.GetStatsFor(ctx, upstream)
+	if err != nil {
+		return 0, err
+	}
+	return stats.Up - minUp, nil
+}
+
+// PeerDowntime pairs a peer with its accumulated downtime.
+type PeerDowntime struct {
+	// Server identifies the peer as set by WithPeerIdentity.
+	Server   string
+	Downtime time.Duration
+}
+
+// GetPeersByDowntime returns the peers of the upstream that have recorded
+// downtime, sorted from the longest to the shortest downtime.
+func (c *Client) GetPeersByDowntime(ctx context.Context, upstream string) ([]PeerDowntime, error) {
+	peers, err := c.GetPeersFor(ctx, upstream)
+	if err != nil {
+		return nil, err
+	}
+	return c.peersByDowntime(peers), nil
+}
+
+func (c *Client) peersByDowntime(peers []Peer) []PeerDowntime {
+	pd := []PeerDowntime{}
+	for _, p := range peers {
+		if p.Downtime == 0 {
+			continue
+		}
+		pd = append(pd, PeerDowntime{Server: c.peerID(p.Server, p.Name), Downtime: p.Downtime})
+	}
+	sort.SliceStable(pd, func(i, j int) bool {
+		return pd[i].Downtime > pd[j].Downtime
+	})
+	return pd
+}
+
+// GetSSLReuseRatioFor returns, for each peer of the upstream keyed as set
+// by WithPeerIdentity, the fraction of TLS handshakes that reused a
+// session. Peers without handshakes report 0.
+func (c *Client) GetSSLReuseRatioFor(ctx context.Context, upstream string) (map[string]float64, error) {
+	res, err := c.getUpstream(ctx, upstream)
+	if err != nil {
+		return nil, err
+	}
+	ratios := make(map[string]float64, len(res.Peers))
+	for _, p := range res.Peers {
+		var ratio float64
+		if p.Ssl.Handshakes > 0 {
+			ratio = float64(p.Ssl.SessionReuses) / float64(p.Ssl.Handshakes)
+		}
+		ratios[c.peerID(p.Server, p.Name)] = ratio
+	}
+	return ratios, nil
+}
+
+// GetResponseCodesFor returns the number of responses per status code,
+// such as "200" or "502", summed across peers in the upstream. The map
+// also holds the totals per status class under the keys "1xx" to "5xx".
+func (c *Client) GetResponseCodesFor(ctx context.Context, upstream string) (map[string]int, error) {
+	res, err := c.getUpstream(ctx, upstream)
+	if err != nil {
+		return nil, err
+	}
+	codes := make(map[string]int)
+	for _, p := range res.Peers {
+		for code, n := range p.Responses.Codes {
+			codes[code] += n
+		}
+		codes["1xx"] += p.Responses.OneXx
+		codes["2xx"] += p.Responses.TwoXx
+		codes["3xx"] += p.Responses.ThreeXx
+		codes["4xx"] += p.Responses.FourXx
+		codes["5xx"] += p.Responses.FiveXx
+	}
+	return codes, nil
+}
+
+// LatencyStats summarises the response and header times of peers in an
+// upstream. Min, Max and Mean are response times.
+type LatencyStats struct {
+	Min  time.Duration
+	Max  time.Duration
+	Mean time.Duration
+
+	HeaderMin  time.Duration
+	HeaderMax  time.Duration
+	HeaderMean time.Duration
+}
+
+// GetLatencyStatsFor returns the minimum, maximum and mean response and
+// header times across peers in the upstream. Peers that served no
+// requests are left out with WithIdlePeersExcluded.
+func (c *Client) GetLatencyStatsFor(ctx context.Context, upstream string) (LatencyStats, error) {
+	peers, err := c.GetPeersFor(ctx, upstream)
+	if err != nil {
+		return LatencyStats{}, err
+	}
+	if c.idlePeersExcluded {
+		active := peers[:0]
+		for _, p := range peers {
+			if p.Requests > 0 {
+				active = append(active, p)
+			}
+		}
+		if len(active) < 1 && len(peers) > 0 {
+			return LatencyStats{}, errors.New("no servers with requests in upstream")
+		}
+		peers = active
+	}
+	return latencyStats(peers)
+}
+
+func latencyStats(peers []Peer) (LatencyStats, error) {
+	if len(peers) < 1 {
+		return LatencyStats{}, ErrNoPeers
+	}
+	ls := LatencyStats{
+		Min:       peers[0].ResponseTime,
+		Max:       peers[0].ResponseTime,
+		HeaderMin: peers[0].HeaderTime,
+		HeaderMax: peers[0].HeaderTime,
+	}
+	var sum, headerSum time.Duration
+	for _, p := range peers {
+		ls.Min = min(ls.Min, p.ResponseTime)
+		ls.Max = max(ls.Max, p.ResponseTime)
+		ls.HeaderMin = min(ls.HeaderMin, p.HeaderTime)
+		ls.HeaderMax = max(ls.HeaderMax, p.HeaderTime)
+		sum += p.ResponseTime
+		headerSum += p.HeaderTime
+	}
+	ls.Mean = sum / time.Duration(len(peers))
+	ls.HeaderMean = headerSum / time.Duration(len(peers))
+	return ls, nil
+}
+
+// GetStreamStatsFor returns stats for the stream (TCP/UDP) upstream.
+func (c *Client) GetStreamStatsFor(ctx context.Context, upstream string) (Stats, error) {
+	return c.statsForProtocol(ctx, ProtocolStream, upstream)
+}
+
+// statsForProtocol works like GetStatsFor for the upstream of the
+// protocol, bypassing the cache.
+func (c *Client) statsForProtocol(ctx context.Context, protocol Protocol, upstream string) (Stats, error) {
+	res, err := c.getUpstreamFor(ctx, protocol.String(), upstream, statsQuery)
+	if err != nil {
+		if c.missingAsZero && isNotFound(err) {
+			return Stats{}, nil
+		}
+		return Stats{}, err
+	}
+	return c.calculateStatsFor(upstream, res)
+}
+
+// DetailedStats holds the aggregated stats of an upstream
+// together with the details of each of its peers.
+type DetailedStats struct {
+	Stats
+	Peers []Peer
+}
+
+// GetDetailedStatsFor returns the stats of the upstream and its peers,
+// for example to show which backend is down rather than only how many.
+func (c *Client) GetDetailedStatsFor(ctx context.Context, upstream string) (DetailedStats, error) {
+	stats, peers, err := c.GetStatsAndPeersFor(ctx, upstream)
+	if err != nil {
+		return DetailedStats{}, err
+	}
+	return DetailedStats{Stats: stats, Peers: peers}, nil
+}
+
+// getUpstream fetches the upstream of the protocol set with WithProtocol.
+func (c *Client) getUpstream(ctx context.Context, upstream string) (responseUpstream, error) {
+	return c.getUpstreamFor(ctx, c.protocol.String(), upstream, "")
+}
+
+// statsQuery narrows upstream responses to the peers,
+// which is all the stats are computed from.
+const statsQuery = "?fields=peers"
+
+// getUpstreamFor fetches the upstream of the protocol, "http" or
+// "stream", adding the query, such as statsQuery, to the request.
+func (c *Client) getUpstreamFor(ctx context.Context, protocol, upstream, query string) (responseUpstream, error) {
+	path, err := upstreamPath(protocol, upstream)
+	if err != nil {
+		return responseUpstream{}, err
+	}
+	ctx = withUpstream(ctx, upstream)
+	var res responseUpstream
+	if err := c.getAPI(ctx, path+query, &res); err != nil {
+		return responseUpstream{}, err
+	}
+	return res, nil
+}
+
+// upstreamPath returns the API path of the upstream, escaping the
+// name so it always stays a single path segment.
+func upstreamPath(protocol, upstream string) (string, error) {
+	if upstream == "" {
+		return "", errors.New("empty upstream name")
+	}
+	return "/" + protocol + "/upstreams/" + url.PathEscape(upstream), nil
+}
+
+func (c *Client) peersFromResponse(res responseUpstream) []Peer {
+	peers := make([]Peer, 0, len(res.Peers))
+	for _, p := range res.Peers {
+		peer := Peer{
+			ID:       p.ID,
+			Server:   p.Server,
+			Name:     p.Name,
+			Backup:   p.Backup,
+			Weight:   p.Weight,
+			State:    p.State,
+			Active:   p.Active,
+			Requests: p.Requests,
+			Fails:    p.Fails,
+			Unavail:  p.Unavail,
+			// NGINX reports downtime in milliseconds.
+			Downtime: time.Duration(p.Downtime) * time.Millisecond,
+			Selected: p.Selected,
+
+			HeaderTime:   time.Duration(p.HeaderTime) * time.Millisecond,
+			ResponseTime: time.Duration(p.ResponseTime) * time.Millisecond,
+		}
+		for _, enrich := range c.peerEnrichers {
+			enrich(&peer)
+		}
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+func (c *Client) calculateStatsFor(upstream string, res responseUpstream) (Stats, error) {
+	if len(res.Peers) < 1 {
+		return Stats{}, ErrNoPeers
+	}
+
+	var stats Stats
+	for _, p := range res.Peers {
+		c.countPeer(&stats, p.Backup, p.State)
+	}
+	if stats.Unknown > 0 {
+		c.logger.Warn("peers in unknown state", "upstream", upstream, "count", stats.Unknown)
+	}
+	return stats, nil
+}
+
+// isUp reports whether a peer in the state counts as up,
+// which draining peers do with WithDrainingCountsAsUp.
+func (c *Client) isUp(state string) bool {
+	return state == "up" || state == "draining" && c.drainingAsUp
+}
+
+// countPeer adds a peer in the state to the stats.
+func (c *Client) countPeer(stats *Stats, backup bool, state string) {
+	if backup {
+		stats.Backup++
+		if c.backupExcluded {
+			return
+		}
+	}
+	stats.Total++
+	switch state {
+	case "up":
+		stats.Up++
+	case "down":
+		stats.Down++
+	case "unavail":
+		stats.Unavail++
+	case "draining":
+		if c.drainingAsUp {
+			stats.Up++
+			return
+		}
+		stats.Draining++
+	case "checking":
+		stats.Checking++
+	case "unhealthy":
+		stats.Unhealthy++
+	default:
+		stats.Unknown++
+	}
+}
+
+// Inventory summarises all upstreams and peers configured on the NGINX node.
+type Inventory struct {
+	Upstreams int
+	Peers     int
+	// States maps a peer state (for example "up" or "down")
+	// to the number of peers in that state.
+	States map[string]int
+}
+
+// GetInventory returns the total number of upstreams and peers, and the
+// number of peers in each state, across the whole NGINX node.
+func (c *Client) GetInventory(ctx context.Context) (Inventory, error) {
+	upstreams, err := c.getUpstreams(ctx, "")
+	if err != nil {
+		return Inventory{}, fmt.Errorf("retrieving upstreams: %w", err)
+	}
+	return inventoryFromResponse(upstreams), nil
+}
+
+func inventoryFromResponse(upstreams map[string]responseUpstream) Inventory {
+	inv := Inventory{
+		Upstreams: len(upstreams),
+		States:    make(map[string]int),
+	}
+	for _, u := range upstreams {
+		inv.Peers += len(u.Peers)
+		for _, p := range u.Peers {
+			inv.States[p.State]++
+		}
+	}
+	return inv
+}
+
+// getUpstreams fetches all upstreams of the client protocol, adding
+// the query, such as statsQuery, to the request.
+func (c *Client) getUpstreams(ctx context.Context, query string) (map[string]responseUpstream, error) {
+	var res map[string]responseUpstream
+	if err := c.getAPI(ctx, "/"+c.protocol.String()+"/upstreams"+query, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *Client) GetUpstreamsFor(ctx context.Context, hostname string) (map[string][]string, error) {
+	return c.upstreamsFor(ctx, "http", hostname)
+}
+
+// GetStreamUpstreamsFor works like GetUpstreamsFor
+// for stream (TCP/UDP) upstreams.
+func (c *Client) GetStreamUpstreamsFor(ctx context.Context, hostname string) (map[string][]string, error) {
+	return c.upstreamsFor(ctx, "stream", hostname)
+}
+
+// hostUpstreams works like GetUpstreamsFor for upstreams of the
+// client protocol, so their names can be passed to getUpstream.
+func (c *Client) hostUpstreams(ctx context.Context, hostname string) (map[string][]string, error) {
+	return c.upstreamsFor(ctx, c.protocol.String(), hostname)
+}
+
+// Protocol is the protocol of an upstream, which selects the API
+// endpoint its stats are read from.
+type Protocol int
+
+const (
+	ProtocolHTTP Protocol = iota
+	ProtocolStream
+)
+
+func (p Protocol) String() string {
+	if p == ProtocolStream {
+		return "stream"
+	}
+	return "http"
+}
+
+// Upstream identifies an upstream together with its protocol.
+type Upstream struct {
+	Name     string
+	Protocol Protocol
+}
+
+// GetAllUpstreamsFor works like GetUpstreamsFor, but returns both the HTTP
+// and the stream upstreams of the host, tagged with their protocol. An
+// endpoint the NGINX API does not serve, such as the stream endpoint when
+// no stream block is configured, contributes no upstreams. Both endpoints
+// are queried concurrently.
+func (c *Client) GetAllUpstreamsFor(ctx context.Context, hostname string) (map[string][]Upstream, error) {
+	protocols := []Protocol{ProtocolHTTP, ProtocolStream}
+	results := make([]map[string][]string, len(protocols))
+	found := make([]bool, len(protocols))
+	g, gctx := errgroup.WithContext(ctx)
+	for i, p := range protocols {
+		i, p := i, p
+		g.Go(func() error {
+			res, err := c.upstreamsFor(gctx, p.String(), hostname)
+			if isNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			results[i], found[i] = res, true
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	// HTTP upstreams are listed before stream upstreams.
+	all := make(map[string][]Upstream)
+	missing := 0
+	for i, p := range protocols {
+		if !found[i] {
+			missing++
+			continue
+		}
+		for host, names := range results[i] {
+			for _, name := range names {
+				all[host] = append(all[host], Upstream{Name: name, Protocol: p})
+			}
+		}
+	}
+	if missing == len(protocols) {
+		return nil, fmt.Errorf("retrieving zones: %w", ErrUpstreamNotFound)
+	}
+	return all, nil
+}
+
+func (c *Client) upstreamsFor(ctx context.Context, protocol, hostname string) (map[string][]string, error) {
+	var res responseZones
+	err := c.getAPI(ctx, "/"+protocol+"/upstreams?fields=zone", &res)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving zones: %w", err)
+	}
+	return c.hostnameUpstreamsFromResponse(hostname, res), nil
+}
+
+// ListHosts returns the sorted, distinct hostnames found in the zone
+// names of the upstreams of the NGINX instance. Upstreams whose zone
+// does not include a hostname are skipped.
+func (c *Client) ListHosts(ctx context.Context) ([]string, error) {
+	var res responseZones
+	if err := c.getAPI(ctx, "/"+c.protocol.String()+"/upstreams?fields=zone", &res); err != nil {
+		return nil, fmt.Errorf("retrieving zones: %w", err)
+	}
+	seen := make(map[string]bool)
+	hosts := []string{}
+	for u, v := range res {
+		host, ok := c.hostFromZone(v.Zone, u)
+		if !ok || seen[host] {
+			continue
+		}
+		seen[host] = true
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts, nil
+}
+
+// UpstreamZoneNotFoundError is returned by GetStatsForZone
+// when no upstream uses the zone.
+type UpstreamZoneNotFoundError struct {
+	Zone string
+}
+
+func (e *UpstreamZoneNotFoundError) Error() string {
+	return fmt.Sprintf("no upstream with zone %s", e.Zone)
+}
+
+// GetStatsForZone returns the stats of the upstream using the shared
+// memory zone, for example "bar.example.org-hg-backend", found in the
+// upstreams listing. When several upstreams share the zone, their
+// stats are summed like GetStatsForUpstreams does.
+func (c *Client) GetStatsForZone(ctx context.Context, zone string) (Stats, error) {
+	if zone == "" {
+		return Stats{}, errors.New("empty zone name")
+	}
+	var res responseZones
+	if err := c.getAPI(ctx, "/"+c.protocol.String()+"/upstreams?fields=zone", &res); err != nil {
+		return Stats{}, fmt.Errorf("retrieving zones: %w", err)
+	}
+	var upstreams []string
+	for u, v := range res {
+		if v.Zone == zone {
+			upstreams = append(upstreams, u)
+		}
+	}
+	switch len(upstreams) {
+	case 0:
+		return Stats{}, &UpstreamZoneNotFoundError{Zone: zone}
+	case 1:
+		return c.GetStatsFor(ctx, upstreams[0])
+	}
+	sort.Strings(upstreams)
+	return c.GetStatsForUpstreams(ctx, upstreams)
+}
+
+// responseZones is the upstreams listing narrowed to the zone
+// of each upstream, keyed by upstream name.
+type responseZones map[string]responseZone
+
+// responseZone holds the zone of an upstream. Entries that are not
+// objects with a string zone decode to an empty zone and are skipped.
+type responseZone struct {
+	Zone string
+}
+
+func (z *responseZone) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Zone string `json:"zone"`
+	}
+	if json.Unmarshal(data, &v) == nil {
+		z.Zone = v.Zone
+	}
+	return nil
+}
+
+func (c *Client) hostnameUpstreamsFromResponse(hostname string, res responseZones) map[string][]string {
+	hostUpstreams := make(map[string][]string)
+	for u, v := range res {
+		if v.Zone == "" {
+			continue
+		}
+		host, _ := c.hostFromZone(v.Zone, u)
+		if host != hostname {
+			continue
+		}
+		hostUpstreams[host] = append(hostUpstreams[host], u)
+	}
+	return hostUpstreams
+}
+
+// hostFromZone extracts the hostname from the zone name. It reports false
+// when the zone does not follow the "hostname-upstream" naming convention,
+// in which case the whole zone name is returned.
+func (c *Client) hostFromZone(zone, upstream string) (string, bool) {
+	if c.zoneParser != nil {
+		host := c.zoneParser(zone)
+		return host, host != ""
+	}
+	// We need to got from this: "bar.example.org-lxr-backend"
+	// to this: "bar.example.org", which is the hostname we
+	// are looking for. The hostname may contain dashes itself,
+	// so we strip the known upstream suffix when it is there.
+	if host, ok := strings.CutSuffix(zone, "-"+upstream); ok {
+		return host, host != ""
+	}
+	host, _, ok := strings.Cut(zone, "-")
+	return host, ok && host != ""
+}
+
+// UnknownHost is the host GetHealthMatrix and ExportCSV report upstreams
+// under when their zone name does not include a hostname.
+const UnknownHost = "unknown"
+
+// GetHealthMatrix returns the stats of every upstream grouped by host,
+// computed from a single API call.
+func (c *Client) GetHealthMatrix(ctx context.Context) (map[string]map[string]Stats, error) {
+	upstreams, err := c.getUpstreams(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("retrieving upstreams: %w", err)
+	}
+	matrix := make(map[string]map[string]Stats)
+	for name, s := range c.statsFromUpstreams(upstreams) {
+		host, ok := c.hostFromZone(upstreams[name].Zone, name)
+		if !ok {
+			host = UnknownHost
+		}
+		if matrix[host] == nil {
+			matrix[host] = make(map[string]Stats)
+		}
+		matrix[host][name] = s
+	}
+	return matrix, nil
+}
+
+// GetAllStats returns stats for every upstream on the NGINX node,
+// keyed by upstream name, computed from a single API call. Upstreams
+// without peers are reported with zero stats.
+func (c *Client) GetAllStats(ctx context.Context) (map[string]Stats, error) {
+	upstreams, err := c.getUpstreams(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("retrieving upstreams: %w", err)
+	}
+	return c.statsFromUpstreams(upstreams), nil
+}
+
+func (c *Client) statsFromUpstreams(upstreams map[string]responseUpstream) map[string]Stats {
+	stats := make(map[string]Stats, len(upstreams))
+	for name, u := range upstreams {
+		s, err := c.calculateStatsFor(name, u)
+		if err != nil {
+			s = Stats{}
+		}
+		stats[name] = s
+	}
+	return stats
+}
+
+func (c *Client) GetStatsForHost(ctx context.Context, hostname string) (stats Stats, err error) {
+	ctx = ensureRequestID(ctx)
+	ctx, span := c.tracer.Start(ctx, "GetStatsForHost", trace.WithAttributes(attribute.String("nginx.host", hostname)))
+	defer func() { endSpan(span, err) }()
+	upstreams, err := c.GetAllUpstreamsFor(ctx, hostname)
+	if err != nil {
+		return Stats{}, fmt.Errorf("getting stats for host %s: %w", hostname, err)
+	}
+	ux, ok := upstreams[hostname]
+	if !ok {
+		return Stats{}, fmt.Errorf("no stat data for host %s", hostname)
+	}
+	names := make(map[Protocol][]string)
+	for _, u := range ux {
+		names[u.Protocol] = append(names[u.Protocol], u.Name)
+	}
+	// Upstreams of the client protocol go through GetStatsForUpstreams,
+	// with its batching and caching; the others are fetched one by one.
+	stats, err = c.GetStatsForUpstreams(ctx, names[c.protocol])
+	other := ProtocolStream
+	if c.protocol == ProtocolStream {
+		other = ProtocolHTTP
+	}
+	if len(names[other]) > 0 {
+		fetch := func(ctx context.Context, upstream string) (Stats, error) {
+			return c.statsForProtocol(ctx, other, upstream)
+		}
+		otherStats, oerr := c.sumResults(ctx, c.resultsFor(ctx, names[other], fetch))
+		stats = stats.add(otherStats)
+		err = errors.Join(err, oerr)
+	}
+	if err != nil {
+		return stats, fmt.Errorf("getting stats for host %s: %w", hostname, err)
+	}
+	c.recordSnapshot(hostname, stats)
+	return stats, nil
+}
+
+// GetStatsForUpstreams returns the summed stats of the upstreams. Upstreams
+// failing to be queried are left out of the stats and their errors are
+// joined in the returned error, so the stats of the others are still
+// available. Upstreams without peers are counted as Empty.
+func (c *Client) GetStatsForUpstreams(ctx context.Context, upstreams []string) (Stats, error) {
+	return c.sumResults(ctx, c.GetResultsForUpstreams(ctx, upstreams))
+}
+
+// sumResults sums the stats of the successful results and joins the
+// errors of the others. Upstreams without peers are counted as Empty
+// rather than failed, so they are not mistaken for a transient error.
+func (c *Client) sumResults(ctx context.Context, results []UpstreamResult) (Stats, error) {
+	var stats Stats
+	var errs []error
+	for _, r := range results {
+		if errors.Is(r.Err, ErrNoPeers) {
+			c.loggerFor(ctx).Warn("upstream has no peers", "upstream", r.Name)
+			stats.Empty++
+			continue
+		}
+		if r.Err != nil {
+			c.loggerFor(ctx).Warn("leaving upstream out of stats", "upstream", r.Name, "error", r.Err)
+			errs = append(errs, fmt.Errorf("upstream %s: %w", r.Name, r.Err))
+			continue
+		}
+		stats = stats.add(r.Stats)
+	}
+	return stats, errors.Join(errs...)
+}
+
+// GetStatsForAllUpstreams returns the summed stats of every upstream
+// of the NGINX instance, collected like GetStatsForUpstreams. An instance
+// without upstreams has zero stats. GetAllStats returns the stats per
+// upstream instead.
+func (c *Client) GetStatsForAllUpstreams(ctx context.Context) (Stats, error) {
+	names, err := c.upstreamNames(ctx, c.protocol.String())
+	if err != nil {
+		return Stats{}, err
+	}
+	if len(names) == 0 {
+		return Stats{}, nil
+	}
+	return c.GetStatsForUpstreams(ctx, names)
+}
+
+// upstreamNames returns the sorted names of the upstreams
+// of the protocol, asking NGINX only for their zones.
+func (c *Client) upstreamNames(ctx context.Context, protocol string) ([]string, error) {
+	var res map[string]json.RawMessage
+	if err := c.getAPI(ctx, "/"+protocol+"/upstreams?fields=zone", &res); err != nil {
+		return nil, fmt.Errorf("retrieving upstreams: %w", err)
+	}
+	names := make([]string, 0, len(res))
+	for name := range res {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// UpstreamResult holds the outcome of collecting stats for a single upstream.
+type UpstreamResult struct {
+	Name  string
+	Stats Stats
+	Err   error
+}
+
+// GetResultsForUpstreams collects stats for each upstream concurrently and
+// returns one result per upstream, in the same order as upstreams. Unlike
+// GetStatsForUpstreams it preserves per-upstream errors. When ctx is done,
+// outstanding requests are cancelled and the upstreams not queried yet
+// report the context error.
+func (c *Client) GetResultsForUpstreams(ctx context.Context, upstreams []string) []UpstreamResult {
+	ctx = ensureRequestID(ctx)
+	if c.batchThreshold > 0 && len(upstreams) >= c.batchThreshold {
+		return c.batchResultsForUpstreams(ctx, upstreams)
+	}
+	return c.resultsFor(ctx, upstreams, c.GetStatsFor)
+}
+
+// resultsFor collects stats for each upstream concurrently with fetch,
+// querying at most maxConcurrency upstreams at the same time.
+func (c *Client) resultsFor(ctx context.Context, upstreams []string, fetch func(context.Context, string) (Stats, error)) []UpstreamResult {
+	stats, errs := fanOut(ctx, c, upstreams, c.failFast, fetch)
+	results := make([]UpstreamResult, len(upstreams))
+	for i, u := range upstreams {
+		results[i] = UpstreamResult{Name: u, Stats: stats[i], Err: errs[i]}
+	}
+	return results
+}
+
+// fanOut calls fetch for each upstream concurrently, with at most
+// maxConcurrency calls in flight, and returns the values and errors in
+// the order of the upstreams. With failFast, the first error cancels
+// the calls still outstanding.
+func fanOut[T any](ctx context.Context, c *Client, upstreams []string, failFast bool, fetch func(context.Context, string) (T, error)) ([]T, []error) {
+	values := make([]T, len(upstreams))
+	errs := make([]error, len(upstreams))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var g errgroup.Group
+	g.SetLimit(c.maxConcurrency)
+	for i, u := range upstreams {
+		// Once the context is done, the remaining
+		// upstreams are not queried at all.
+		if err := ctx.Err(); err != nil {
+			errs[i] = err
+			continue
+		}
+		i, u := i, u
+		g.Go(func() error {
+			values[i], errs[i] = fetch(ctx, u)
+			if errs[i] != nil && failFast {
+				cancel()
+			}
+			return nil
+		})
+	}
+	g.Wait()
+	return values, errs
+}
+
+// Generation returns the NGINX configuration generation seen by the
+// last reload check, or zero if no check was made yet.
+func (c *Client) Generation() int64 {
+	c.generationMu.Lock()
+	defer c.generationMu.Unlock()
+	return c.generation
+}
+
+// checkGeneration fetches the configuration generation and reports
+// whether it changed since the previous check. The first check never
+// reports a change.
+func (c *Client) checkGeneration(ctx context.Context) (prev, cur int64, changed bool, err error) {
+	var res struct {
+		Generation int64 `json:"generation"`
+	}
+	if err := c.getAPI(ctx, "/nginx?fields=generation", &res); err != nil {
+		return 0, 0, false, fmt.Errorf("retrieving generation: %w", err)
+	}
+	c.generationMu.Lock()
+	defer c.generationMu.Unlock()
+	prev, c.generation = c.generation, res.Generation
+	return prev, res.Generation, prev != 0 && prev != res.Generation, nil
+}
+
+// batchResultsForUpstreams fetches all upstreams in one call and picks
+// the requested ones from the response. Upstreams cached with WithCache
+// are served from the cache, and no call is made when all of them are.
+func (c *Client) batchResultsForUpstreams(ctx context.Context, upstreams []string) []UpstreamResult {
+	results := make([]UpstreamResult, len(upstreams))
+	var missing []int
+	for i, name := range upstreams {
+		results[i].Name = name
+		if c.statsCacheTTL > 0 {
+			if e, ok := c.cachedStats(name); ok {
+				results[i].Stats = e.stats
+				continue
+			}
+		}
+		missing = append(missing, i)
+	}
+	if len(missing) == 0 {
+		return results
+	}
+
+	fetchedAt := c.clock.Now()
+	all, err := c.getUpstreams(ctx, statsQuery)
+	for _, i := range missing {
+		name := upstreams[i]
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		res, ok := all[name]
+		if !ok {
+			if !c.missingAsZero {
+				results[i].Err = fmt.Errorf("upstream %s: %w", name, ErrUpstreamNotFound)
+			}
+			continue
+		}
+		results[i].Stats, results[i].Err = c.calculateStatsFor(name, res)
+		if results[i].Err == nil && c.statsCacheTTL > 0 {
+			c.cacheStats(name, results[i].Stats, fetchedAt)
+		}
+	}
+	return results
+}
+
+var (
+	// ErrUpstreamNotFound is returned when the NGINX API responds
+	// with 404, for example for a misspelled upstream name.
+	ErrUpstreamNotFound = errors.New("upstream not found")
+	// ErrUnauthorized is returned when the NGINX API responds with
+	// 401 or 403.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrUnsupportedVersion is returned by Ping when NGINX does
+	// not support the API version used by the client.
+	ErrUnsupportedVersion = errors.New("unsupported NGINX API version")
+	// ErrNoPeers is returned for the stats, latency, health or score
+	// of an upstream that has no peers. Summed stats count such
+	// upstreams as Empty instead.
+	ErrNoPeers = errors.New("no servers in upstream")
+)
+
+// APIError is returned for a response with a status other than 200.
+// Code, Text and RequestID are taken from the error object NGINX
+// sends in the body, and are empty when the body holds none.
+type APIError struct {
+	StatusCode int
+	Code       string
+	Text       string
+	RequestID  string
+	// retryAfter is the delay requested by a 503
+	// response with a Retry-After header.
+	retryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("got response code: %v", e.StatusCode)
+	if e.Code != "" {
+		msg += ": " + e.Code
+	}
+	if e.Text != "" {
+		msg += ": " + e.Text
+	}
+	if e.RequestID != "" {
+		msg += " (request_id: " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// Unwrap maps the response code to a sentinel error, if any,
+// so callers can match it with errors.Is.
+func (e *APIError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return ErrUpstreamNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, ErrUpstreamNotFound)
+}
+
+// getAPI requests the path relative to the versioned API root,
+// for example "/http/upstreams".
+func (c *Client) getAPI(ctx context.Context, path string, data interface{}) error {
+	return c.doAPI(ctx, http.MethodGet, path, nil, data)
+}
+
+// doAPI sends a request to the path relative to the versioned API root,
+// falling back to a lower API version when enabled.
+func (c *Client) doAPI(ctx context.Context, method, path string, body, out interface{}) error {
+	_, err := c.doAPIVersioned(ctx, method, path, body, out)
+	return c.withRequestID(ctx, err)
+}
+
+// withRequestID annotates err with the request ID carried by ctx.
+func (c *Client) withRequestID(ctx context.Context, err error) error {
+	if id, ok := RequestIDFrom(ctx); err != nil && ok {
+		return fmt.Errorf("request %s: %w", id, err)
+	}
+	return err
+}
+
+// doAPIVersioned sends the request to the API version in use and
+// returns the URL the request was last sent to.
+func (c *Client) doAPIVersioned(ctx context.Context, method, path string, body, out interface{}) (string, error) {
+	version := c.negotiatedVersion(ctx)
+	url := c.apiURL(version, path)
+	err := c.do(ctx, method, url, body, out)
+	if err == nil || !c.versionFallback || !isNotFound(err) {
+		return url, err
+	}
+	ok, ferr := c.fallbackVersion(ctx, version)
+	if ferr != nil {
+		return url, fmt.Errorf("%w (version fallback: %v)", err, ferr)
+	}
+	if !ok {
+		return url, err
+	}
+	url = c.apiURL(c.Version(), path)
+	return url, c.do(ctx, method, url, body, out)
+}
+
+// negotiatedVersion returns the API version in use, discovering
+// it first when WithAutoVersion is set.
+func (c *Client) negotiatedVersion(ctx context.Context) int {
+	if c.autoVersion {
+		c.autoOnce.Do(func() { c.discoverVersion(ctx) })
+	}
+	return c.Version()
+}
+
+func (c *Client) apiURL(version int, path string) string {
+	return fmt.Sprintf("%s%d%s", c.apiRoot(), version, path)
+}
+
+// apiRoot returns the URL of the API root listing the supported versions.
+func (c *Client) apiRoot() string {
+	return c.baseURL + c.apiPath + "/"
+}
+
+// ping checks that the NGINX API responds.
+func (c *Client) ping(ctx context.Context) error {
+	if err := c.do(ctx, http.MethodGet, c.apiRoot(), nil, nil); err != nil {
+		return c.withRequestID(ctx, fmt.Errorf("reaching NGINX API: %w", err))
+	}
+	return nil
+}
+
+// Ping checks that the NGINX API responds and supports the API version
+// used by the client. It returns an error wrapping ErrUnsupportedVersion
+// and naming the supported versions otherwise.
+func (c *Client) Ping(ctx context.Context) error {
+	versions, err := c.apiVersions(ctx)
+	if err != nil {
+		return c.withRequestID(ctx, fmt.Errorf("reaching NGINX API: %w", err))
+	}
+	v := c.Version()
+	for _, sv := range versions {
+		if sv == v {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %d, NGINX supports %v", ErrUnsupportedVersion, v, versions)
+}
+
+// apiVersions returns the API versions listed by the NGINX API root.
+func (c *Client) apiVersions(ctx context.Context) ([]int, error) {
+	var versions []int
+	if err := c.do(ctx, http.MethodGet, c.apiRoot(), nil, &versions); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// DiscoverVersion returns the highest API version listed by the NGINX
+// API root. It does not change the version used by the client.
+func (c *Client) DiscoverVersion(ctx context.Context) (int, error) {
+	versions, err := c.apiVersions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	highest := 0
+	for _, v := range versions {
+		if v > highest {
+			highest = v
+		}
+	}
+	if highest < minVersion {
+		return 0, fmt.Errorf("no supported NGINX API version in %v", versions)
+	}
+	return highest, nil
+}
+
+// discoverVersion switches the client to the discovered API version,
+// keeping the current one when discovery fails.
+func (c *Client) discoverVersion(ctx context.Context) {
+	v, err := c.DiscoverVersion(ctx)
+	if err != nil {
+		c.loggerFor(ctx).Warn("discovering NGINX API version", "error", err, "version", c.Version())
+		return
+	}
+	c.mu.Lock()
+	c.version = v
+	c.mu.Unlock()
+	c.loggerFor(ctx).Debug("discovered NGINX API version", "version", v)
+}
+
+// fallbackVersion checks whether the API root for the failed version
+// exists. If it does not, it probes lower versions and switches the client
+// to the first one that responds. It reports whether the caller should retry.
+func (c *Client) fallbackVersion(ctx context.Context, failed int) (bool, error) {
+	c.fallbackMu.Lock()
+	defer c.fallbackMu.Unlock()
+
+	if c.Version() != failed {
+		// Another request already switched the version.
+		return true, nil
+	}
+	var res interface{}
+	err := c.do(ctx, http.MethodGet, c.apiURL(failed, "/"), nil, &res)
+	if err == nil {
+		// The version is supported, so the 404 is genuine.
+		return false, nil
+	}
+	if !isNotFound(err) {
+		return false, err
+	}
+	for v := failed - 1; v >= minVersion; v-- {
+		err := c.do(ctx, http.MethodGet, c.apiURL(v, "/"), nil, &res)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		c.mu.Lock()
+		c.version = v
+		c.mu.Unlock()
+		c.loggerFor(ctx).Info("falling back to lower NGINX API version", "configured", failed, "version", v)
+		return true, nil
+	}
+	return false, fmt.Errorf("no supported NGINX API version below %d", failed)
+}
+
+// do sends a request with the JSON encoded body, if not nil, and decodes
+// the JSON response into out, if not nil. GET requests are retried as
+// configured with WithRetries, and concurrent GETs of the same URL
+// share one request.
+func (c *Client) do(ctx context.Context, method, url string, body, out interface{}) error {
+	if _, ok := ctx.Deadline(); !ok && c.defaultRequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.defaultRequestTimeout)
+		defer cancel()
+	}
+
+	if method == http.MethodGet && out != nil {
+		return c.sharedGet(ctx, url, out)
+	}
+	return c.send(ctx, method, url, body, out)
+}
+
+// sharedCall is a GET shared by concurrent callers. Its context is
+// cancelled once no caller waits for it any more, so the request is
+// bounded by the latest deadline of its callers, which do gives every
+// caller without one when WithDefaultRequestTimeout is set.
+type sharedCall struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiters int
+}
+
+// sharedGet sends a GET of the URL, sharing a single request and its
+// decoded response, which callers must not modify, with concurrent GETs
+// of the same URL into the same type. The request does not end when one
+// caller gives up, only when all of them did, and each caller stops
+// waiting when its own context is done.
+func (c *Client) sharedGet(ctx context.Context, url string, out interface{}) error {
+	t := reflect.TypeOf(out).Elem()
+	key := t.String() + " " + url
+
+	c.sharedMu.Lock()
+	call, ok := c.sharedCalls[key]
+	if !ok {
+		// The values of the context, such as the request ID
+		// and the trace span, still apply to the request.
+		sctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &sharedCall{ctx: sctx, cancel: cancel}
+		if c.sharedCalls == nil {
+			c.sharedCalls = make(map[string]*sharedCall)
+		}
+		c.sharedCalls[key] = call
+	}
+	call.waiters++
+	c.sharedMu.Unlock()
+	defer c.leaveSharedCall(key, call)
+
+	// Keying the flight by the call keeps a caller that arrives after a
+	// call was cancelled from joining the request it cancelled.
+	ch := c.inflight.DoChan(fmt.Sprintf("%s %p", key, call), func() (interface{}, error) {
+		res := reflect.New(t)
+		err := c.send(call.ctx, http.MethodGet, url, nil, res.Interface())
+		return res.Elem(), err
+	})
+	if c.sharedJoined != nil {
+		c.sharedJoined()
+	}
+	select {
+	case r := <-ch:
+		if r.Err != nil {
+			return r.Err
+		}
+		reflect.ValueOf(out).Elem().Set(r.Val.(reflect.Value))
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// leaveSharedCall removes a caller from the call, cancelling
+// it when the caller was the last one waiting.
+func (c *Client) leaveSharedCall(key string, call *sharedCall) {
+	c.sharedMu.Lock()
+	defer c.sharedMu.Unlock()
+	call.waiters--
+	if call.waiters > 0 {
+		return
+	}
+	call.cancel()
+	if c.sharedCalls[key] == call {
+		delete(c.sharedCalls, key)
+	}
+}
+
+// send sends the request, retrying GETs that failed with
+// a transient error when retries are enabled.
+func (c *Client) send(ctx context.Context, method, url string, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("encoding request body: %w", err)
+		}
+		payload = b
+	}
+
+	retries := 0
+	if method == http.MethodGet {
+		retries = c.retries
+	}
+	delay := c.retryBaseDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := c.doOnce(ctx, method, url, payload, out)
+		if err == nil || !retry || attempt >= retries {
+			return err
+		}
+		// The server may ask for a longer wait, for example
+		// while NGINX reloads. The context still bounds it.
+		wait := delay
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.retryAfter > wait {
+			wait = apiErr.retryAfter
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+			delay *= 2
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w (retrying: %v)", err, ctx.Err())
+		}
+	}
+}
+
+// parseRetryAfter returns the delay in a Retry-After header, given
+// either in seconds or as an HTTP date, or zero when there is none.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// doOnce sends a single request and reports whether a failure
+// is transient: a connection error or a 5xx response.
+func (c *Client) doOnce(ctx context.Context, method, url string, payload []byte, out interface{}) (retry bool, err error) {
+	ctx, span := c.startRequestSpan(ctx, method, url)
+	defer func() { endSpan(span, err) }()
+
+	var reqBody io.Reader
+	if payload != nil {
+		reqBody = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return false, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+	if c.basicAuth != nil {
+		req.SetBasicAuth(c.basicAuth.user, c.basicAuth.pass)
+	}
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+	c.metrics.observe(time.Since(start), err == nil && resp.StatusCode == http.StatusOK)
+	if err != nil {
+		c.loggerFor(ctx).Debug("NGINX API request failed", "method", method, "url", url, "duration", time.Since(start), "error", err)
+		return ctx.Err() == nil, fmt.Errorf("sending request: %w", err)
+	}
+	defer closeBody(resp.Body)
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	c.loggerFor(ctx).Debug("NGINX API request", "method", method, "url", url, "status", resp.StatusCode, "duration", time.Since(start))
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := newAPIError(resp)
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			apiErr.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		}
+		return resp.StatusCode >= 500, apiErr
+	}
+	if out == nil {
+		return false, nil
+	}
+
+	// The body is decoded as it is read. Only its start is kept,
+	// to describe a body that fails to decode.
+	head := &prefixWriter{max: maxBodySnippet}
+	if err := json.NewDecoder(io.TeeReader(resp.Body, head)).Decode(out); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &syntaxErr) && !errors.As(err, &typeErr) && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return true, fmt.Errorf("reading response body: %w", err)
+		}
+		return false, decodeError(url, resp.Header.Get("Content-Type"), head.buf, err)
+	}
+	return false, nil
+}
+
+// newAPIError returns the error for a failed response, with the
+// details of the NGINX error object in its body, if any.
+func newAPIError(resp *http.Response) *APIError {
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+			Text string `json:"text"`
+		} `json:"error"`
+		RequestID string `json:"request_id"`
+	}
+	// A body that is not an NGINX error object, such as an
+	// HTML error page of a proxy, leaves only the status code.
+	if json.NewDecoder(io.LimitReader(resp.Body, maxDrain)).Decode(&body) == nil {
+		apiErr.Code = body.Error.Code
+		apiErr.Text = body.Error.Text
+		apiErr.RequestID = body.RequestID
+	}
+	return apiErr
+}
+
+// maxDrain is the number of unread response body bytes
+// read before closing the body to reuse the connection.
+const maxDrain = 4 << 10
+
+// closeBody reads what is left of a small response body and closes it,
+// so the transport can reuse the connection for the next request.
+func closeBody(body io.ReadCloser) {
+	io.CopyN(io.Discard, body, maxDrain)
+	body.Close()
+}
+
+// prefixWriter keeps the first max bytes written to it
+// and discards the rest.
+type prefixWriter struct {
+	buf []byte
+	max int
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	if n := w.max - len(w.buf); n > 0 {
+		w.buf = append(w.buf, p[:min(n, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// maxBodySnippet is the number of response body
+// bytes included in decoding errors.
+const maxBodySnippet = 200
+
+// decodeError describes a response body that could not be decoded,
+// including the start of the body. A body that is not JSON at all, such
+// as the login page of a proxy, is reported by its content type.
+func decodeError(url, contentType string, snippet []byte, err error) error {
+	if mt, _, perr := mime.ParseMediaType(contentType); contentType != "" && (perr != nil || mt != "application/json") {
+		return fmt.Errorf("unmarshaling response body from %s: unexpected content type %q, want application/json (body: %q): %w", url, contentType, snippet, err)
+	}
+	return fmt.Errorf("unmarshaling response body from %s (body: %q): %w", url, snippet, err)
 }
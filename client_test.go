@@ -1,13 +1,20 @@
 package nginxhealthz_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -64,19 +71,89 @@ func TestNewClient_FailsOnInvalidVersion(t *testing.T) {
 
 	_, err := nginxhealthz.NewClient(
 		"http://localhost:9001",
-		nginxhealthz.WithVersion(9),
+		nginxhealthz.WithVersion(3),
 	)
 	if err == nil {
 		t.Fatal("want err on invalid NGINX version")
 	}
 }
 
+func TestNewClient_AcceptsVersionNineAndNewer(t *testing.T) {
+	t.Parallel()
+
+	for _, v := range []int{9, 10} {
+		c, err := nginxhealthz.NewClient("http://localhost:9001", nginxhealthz.WithVersion(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.Version(); got != v {
+			t.Errorf("want version %d, got %d", v, got)
+		}
+	}
+}
+
+func TestNewClient_UsesLatestVersionListedByAPI(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/" {
+			t.Errorf("unexpected path %q", r.URL.Path)
+		}
+		io.WriteString(w, `[1,2,3,4,5,6,7,8,9]`)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithLatestVersion())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Version(); got != 9 {
+		t.Errorf("want version 9, got %d", got)
+	}
+}
+
+func TestNewClient_FailsWhenLatestVersionCannotBeDiscovered(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	if _, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithLatestVersion()); err == nil {
+		t.Fatal("want error")
+	}
+}
+
 func TestNewClient_FailsOnInvalidBaseURL(t *testing.T) {
 	t.Parallel()
 
-	_, err := nginxhealthz.NewClient("")
-	if err == nil {
-		t.Fatal("want error on invalid base URL")
+	for _, baseURL := range []string{
+		"",
+		"localhost:9001",
+		"//localhost:9001",
+		"/api",
+		"ftp://localhost:9001",
+		"http://",
+	} {
+		if _, err := nginxhealthz.NewClient(baseURL); err == nil {
+			t.Errorf("want error on invalid base URL %q", baseURL)
+		}
+	}
+}
+
+func TestNewClient_StripsTrailingSlashFromBaseURL(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(validResponseGetUpstreamAllServersUp, "/api/8/http/upstreams/demo-backend?fields=peers", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStatsFor(context.Background(), "demo-backend"); err != nil {
+		t.Fatal(err)
 	}
 }
 
@@ -84,7 +161,7 @@ func TestClientCallsValidPath(t *testing.T) {
 	t.Parallel()
 
 	var called bool
-	wantURI := "/api/8/http/upstreams/demo-backend"
+	wantURI := "/api/8/http/upstreams/demo-backend?fields=peers"
 
 	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 		gotReqURI := r.RequestURI
@@ -118,7 +195,7 @@ func TestClientGetsStatsOnValidInputWithAllServersUp(t *testing.T) {
 
 	ts := newTestServerWithPathValidator(
 		validResponseGetUpstreamAllServersUp,
-		"/api/8/http/upstreams/demo-backend", t,
+		"/api/8/http/upstreams/demo-backend?fields=peers", t,
 	)
 	defer ts.Close()
 
@@ -186,7 +263,10 @@ func TestGetStatsForHost_ReturnsCorrectResultsForValidHost(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	got := c.GetStatsForUpstreams(context.Background(), []string{"hg-backend", "lxr-backend"})
+	got, err := c.GetStatsForUpstreams(context.Background(), []string{"hg-backend", "lxr-backend"})
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	want := nginxhealthz.Stats{
 		Total: 4,
@@ -199,313 +279,3061 @@ func TestGetStatsForHost_ReturnsCorrectResultsForValidHost(t *testing.T) {
 	}
 }
 
-var (
-	validResponseUpstreamLXRbackend = `{
+func TestGetStatsAndPeersFor_ReturnsStatsAndPeersFromSingleCall(t *testing.T) {
+	t.Parallel()
+
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(validResponseUpstreamHGbackend))
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotStats, gotPeers, err := c.GetStatsAndPeersFor(context.Background(), "hg-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("want 1 API call, got %d", calls)
+	}
+
+	wantStats := nginxhealthz.Stats{Total: 2, Up: 1, Down: 1}
+	if !cmp.Equal(wantStats, gotStats) {
+		t.Error(cmp.Diff(wantStats, gotStats))
+	}
+
+	wantPeers := []nginxhealthz.Peer{
+		{
+			ID:       0,
+			Server:   "10.0.0.42:8084",
+			Name:     "10.0.0.42:8084",
+			Weight:   1,
+			State:    "up",
+			Active:   1,
+			Requests: 19803806,
+			Fails:    2583043,
+			Selected: time.Date(2022, 10, 17, 20, 38, 40, 0, time.UTC),
+
+			HeaderTime:   10 * time.Millisecond,
+			ResponseTime: 10 * time.Millisecond,
+		},
+		{
+			ID:       1,
+			Server:   "10.0.0.41:8084",
+			Name:     "10.0.0.41:8084",
+			Weight:   1,
+			State:    "down",
+			Requests: 21808225,
+			Fails:    2583040,
+			Downtime: 1012 * time.Millisecond,
+			Selected: time.Date(2022, 10, 17, 20, 38, 35, 0, time.UTC),
+
+			HeaderTime:   11 * time.Millisecond,
+			ResponseTime: 11 * time.Millisecond,
+		},
+	}
+	if !cmp.Equal(wantPeers, gotPeers) {
+		t.Error(cmp.Diff(wantPeers, gotPeers))
+	}
+}
+
+func TestGetInventory_ReturnsTotalsAcrossAllUpstreams(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(
+		validResponseGetAllUpstreams,
+		"/api/8/http/upstreams", t,
+	)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.GetInventory(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := nginxhealthz.Inventory{
+		Upstreams: 2,
+		Peers:     4,
+		States:    map[string]int{"up": 3, "down": 1},
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetPeersFor_AppliesEnrichersInOrder(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(
+		validResponseUpstreamHGbackend,
+		"/api/8/http/upstreams/hg-backend", t,
+	)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL,
+		nginxhealthz.WithPeerEnricher(func(p *nginxhealthz.Peer) {
+			p.Name = "rack-1/" + p.Name
+		}),
+		nginxhealthz.WithPeerEnricher(func(p *nginxhealthz.Peer) {
+			p.Name = "dc-1/" + p.Name
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peers, err := c.GetPeersFor(context.Background(), "hg-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, p := range peers {
+		got = append(got, p.Name)
+	}
+	want := []string{"dc-1/rack-1/10.0.0.42:8084", "dc-1/rack-1/10.0.0.41:8084"}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestNewClient_FailsOnNilPeerEnricher(t *testing.T) {
+	t.Parallel()
+
+	_, err := nginxhealthz.NewClient("http://localhost:9001", nginxhealthz.WithPeerEnricher(nil))
+	if err == nil {
+		t.Fatal("want error on nil peer enricher")
+	}
+}
+
+func TestGetResultsForUpstreams_ReportsPerUpstreamErrors(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/missing-backend") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(validResponseUpstreamHGbackend))
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := c.GetResultsForUpstreams(context.Background(), []string{"hg-backend", "missing-backend"})
+	if len(got) != 2 {
+		t.Fatalf("want 2 results, got %d", len(got))
+	}
+	if got[0].Name != "hg-backend" || got[0].Err != nil {
+		t.Errorf("want successful result for hg-backend, got %+v", got[0])
+	}
+	want := nginxhealthz.Stats{Total: 2, Up: 1, Down: 1}
+	if !cmp.Equal(want, got[0].Stats) {
+		t.Error(cmp.Diff(want, got[0].Stats))
+	}
+	if got[1].Name != "missing-backend" || got[1].Err == nil {
+		t.Errorf("want error for missing-backend, got %+v", got[1])
+	}
+}
+
+func TestGetAllStats_ReturnsStatsPerUpstreamFromSingleCall(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(
+		validResponseGetAllUpstreams,
+		"/api/8/http/upstreams", t,
+	)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.GetAllStats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]nginxhealthz.Stats{
+		"hg-backend":  {Total: 2, Up: 1, Down: 1},
+		"lxr-backend": {Total: 2, Up: 2, Down: 0},
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetHealthSummaryFor_DecodesNarrowedResponse(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
 		"peers": [
 			{
-				"id": 0,
 				"server": "10.0.0.42:8084",
-				"name": "10.0.0.42:8084",
-				"backup": false,
-				"weight": 1,
-				"state": "up",
-				"active": 1,
-				"ssl": {
-					"handshakes": 19803694,
-					"handshakes_failed": 0,
-					"session_reuses": 19803274
-				},
-				"requests": 19803806,
-				"header_time": 10,
-				"response_time": 10,
-				"responses": {
-					"1xx": 0,
-					"2xx": 17210560,
-					"3xx": 8364,
-					"4xx": 1721,
-					"5xx": 0,
-					"codes": {
-						"200": 17210560,
-						"301": 7922,
-						"304": 442,
-						"400": 1,
-						"404": 1086,
-						"405": 634
-					},
-					"total": 17220645
-				},
-				"sent": 7525574329,
-				"received": 16975650045,
-				"fails": 2583043,
-				"unavail": 0,
-				"health_checks": {
-					"checks": 2852546,
-					"fails": 0,
-					"unhealthy": 0,
-					"last_passed": true
-				},
-				"downtime": 0,
-				"selected": "2022-10-17T20:38:40Z"
+				"health_checks": {"checks": 10, "fails": 0, "unhealthy": 0, "last_passed": true}
 			},
 			{
-				"id": 1,
 				"server": "10.0.0.41:8084",
-				"name": "10.0.0.41:8084",
-				"backup": false,
-				"weight": 1,
-				"state": "up",
-				"active": 0,
-				"ssl": {
-					"handshakes": 21808054,
-					"handshakes_failed": 0,
-					"session_reuses": 21807458
-				},
-				"requests": 21808225,
-				"header_time": 11,
-				"response_time": 11,
-				"responses": {
-					"1xx": 0,
-					"2xx": 19223635,
-					"3xx": 275,
-					"4xx": 1089,
-					"5xx": 0,
-					"codes": {
-						"200": 19223634,
-						"206": 1,
-						"301": 100,
-						"304": 175,
-						"404": 623,
-						"405": 466
-					},
-					"total": 19224999
-				},
-				"sent": 10110237497,
-				"received": 18490496811,
-				"fails": 2583040,
-				"unavail": 0,
-				"health_checks": {
-					"checks": 2842133,
-					"fails": 1,
-					"unhealthy": 1,
-					"last_passed": true
-				},
-				"downtime": 1012,
-				"selected": "2022-10-17T20:38:35Z"
+				"health_checks": {"checks": 10, "fails": 3, "unhealthy": 1, "last_passed": false}
 			}
-		],
-		"keepalive": 0,
-		"zombies": 0,
-		"zone": "bar.example.org-lxr-backend"
-	}`
+		]
+	}`, "/api/8/http/upstreams/hg-backend?fields=peers", t)
+	defer ts.Close()
 
-	validResponseUpstreamHGbackend = `{
-		"peers": [
-			{
-				"id": 0,
-				"server": "10.0.0.42:8084",
-				"name": "10.0.0.42:8084",
-				"backup": false,
-				"weight": 1,
-				"state": "up",
-				"active": 1,
-				"ssl": {
-					"handshakes": 19803694,
-					"handshakes_failed": 0,
-					"session_reuses": 19803274
-				},
-				"requests": 19803806,
-				"header_time": 10,
-				"response_time": 10,
-				"responses": {
-					"1xx": 0,
-					"2xx": 17210560,
-					"3xx": 8364,
-					"4xx": 1721,
-					"5xx": 0,
-					"codes": {
-						"200": 17210560,
-						"301": 7922,
-						"304": 442,
-						"400": 1,
-						"404": 1086,
-						"405": 634
-					},
-					"total": 17220645
-				},
-				"sent": 7525574329,
-				"received": 16975650045,
-				"fails": 2583043,
-				"unavail": 0,
-				"health_checks": {
-					"checks": 2852546,
-					"fails": 0,
-					"unhealthy": 0,
-					"last_passed": true
-				},
-				"downtime": 0,
-				"selected": "2022-10-17T20:38:40Z"
-			},
-			{
-				"id": 1,
-				"server": "10.0.0.41:8084",
-				"name": "10.0.0.41:8084",
-				"backup": false,
-				"weight": 1,
-				"state": "down",
-				"active": 0,
-				"ssl": {
-					"handshakes": 21808054,
-					"handshakes_failed": 0,
-					"session_reuses": 21807458
-				},
-				"requests": 21808225,
-				"header_time": 11,
-				"response_time": 11,
-				"responses": {
-					"1xx": 0,
-					"2xx": 19223635,
-					"3xx": 275,
-					"4xx": 1089,
-					"5xx": 0,
-					"codes": {
-						"200": 19223634,
-						"206": 1,
-						"301": 100,
-						"304": 175,
-						"404": 623,
-						"405": 466
-					},
-					"total": 19224999
-				},
-				"sent": 10110237497,
-				"received": 18490496811,
-				"fails": 2583040,
-				"unavail": 0,
-				"health_checks": {
-					"checks": 2842133,
-					"fails": 1,
-					"unhealthy": 1,
-					"last_passed": true
-				},
-				"downtime": 1012,
-				"selected": "2022-10-17T20:38:35Z"
-			}
-		],
-		"keepalive": 0,
-		"zombies": 0,
-		"zone": "bar.example.org-hg-backend"
-	}`
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	validResponseGetUpstreamAllServersUp = `{
+	got, err := c.GetHealthSummaryFor(context.Background(), "hg-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []nginxhealthz.PeerHealth{
+		{Server: "10.0.0.42:8084", Checks: 10, LastPassed: true},
+		{Server: "10.0.0.41:8084", Checks: 10, Fails: 3, Unhealthy: 1},
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetHealthCheckStatsFor_SumsChecksAndReportsLastPassedPerPeer(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
 		"peers": [
 			{
-				"id": 0,
 				"server": "10.0.0.42:8084",
-				"name": "10.0.0.42:8084",
-				"backup": false,
-				"weight": 1,
-				"state": "up",
-				"active": 1,
-				"ssl": {
-					"handshakes": 19803694,
-					"handshakes_failed": 0,
-					"session_reuses": 19803274
-				},
-				"requests": 19803806,
-				"header_time": 10,
-				"response_time": 10,
-				"responses": {
-					"1xx": 0,
-					"2xx": 17210560,
-					"3xx": 8364,
-					"4xx": 1721,
-					"5xx": 0,
-					"codes": {
-						"200": 17210560,
-						"301": 7922,
-						"304": 442,
-						"400": 1,
-						"404": 1086,
-						"405": 634
-					},
-					"total": 17220645
-				},
-				"sent": 7525574329,
-				"received": 16975650045,
-				"fails": 2583043,
-				"unavail": 0,
-				"health_checks": {
-					"checks": 2852546,
-					"fails": 0,
-					"unhealthy": 0,
-					"last_passed": true
-				},
-				"downtime": 0,
-				"selected": "2022-10-17T20:38:40Z"
+				"health_checks": {"checks": 10, "fails": 0, "unhealthy": 0, "last_passed": true}
 			},
 			{
-				"id": 1,
 				"server": "10.0.0.41:8084",
-				"name": "10.0.0.41:8084",
-				"backup": false,
-				"weight": 1,
-				"state": "up",
-				"active": 0,
-				"ssl": {
-					"handshakes": 21808054,
-					"handshakes_failed": 0,
-					"session_reuses": 21807458
-				},
-				"requests": 21808225,
-				"header_time": 11,
-				"response_time": 11,
-				"responses": {
-					"1xx": 0,
-					"2xx": 19223635,
-					"3xx": 275,
-					"4xx": 1089,
-					"5xx": 0,
-					"codes": {
-						"200": 19223634,
-						"206": 1,
-						"301": 100,
-						"304": 175,
-						"404": 623,
-						"405": 466
-					},
-					"total": 19224999
-				},
-				"sent": 10110237497,
-				"received": 18490496811,
-				"fails": 2583040,
-				"unavail": 0,
-				"health_checks": {
-					"checks": 2842133,
-					"fails": 1,
-					"unhealthy": 1,
-					"last_passed": true
-				},
-				"downtime": 1012,
-				"selected": "2022-10-17T20:38:35Z"
+				"health_checks": {"checks": 10, "fails": 3, "unhealthy": 1, "last_passed": false}
 			}
-		],
-		"keepalive": 0,
-		"zombies": 0,
-		"zone": "demo-backend"
-	}`
+		]
+	}`, "/api/8/http/upstreams/hg-backend?fields=peers", t)
+	defer ts.Close()
 
-	validResponseGetUpstreamsZones = `{
-		"demo-backend": {
-			"zone": "foo.example.com-demo-backend"
-		},
-		"trac-backend": {
-			"zone": "bar.example.com-trac-backend"
-		},
-		"hg-backend": {
-			"zone": "bar.example.org-hg-backend"
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetHealthCheckStatsFor(context.Background(), "hg-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.HealthCheckStats{
+		Checks:    20,
+		Fails:     3,
+		Unhealthy: 1,
+		Passing:   1,
+		Failing:   1,
+		LastPassed: map[string]bool{
+			"10.0.0.42:8084": true,
+			"10.0.0.41:8084": false,
 		},
-		"lxr-backend": {
-			"zone": "bar.example.org-lxr-backend"
-		}
-	}`
-)
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetLatencyStatsFor_ReturnsMinMaxAndMeanResponseTime(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"peers": [
+			{"server": "10.0.0.1:80", "state": "up", "response_time": 10},
+			{"server": "10.0.0.2:80", "state": "up", "response_time": 20},
+			{"server": "10.0.0.3:80", "state": "up", "response_time": 60}
+		]
+	}`, "/api/8/http/upstreams/demo-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.GetLatencyStatsFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.LatencyStats{
+		Min:  10 * time.Millisecond,
+		Max:  60 * time.Millisecond,
+		Mean: 30 * time.Millisecond,
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetLatencyStatsFor_ReturnsHeaderTimes(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"peers": [
+			{"server": "10.0.0.1:80", "state": "up", "requests": 5, "header_time": 4, "response_time": 10},
+			{"server": "10.0.0.2:80", "state": "up", "requests": 7, "header_time": 8, "response_time": 30}
+		]
+	}`, "/api/8/http/upstreams/demo-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.GetLatencyStatsFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.LatencyStats{
+		Min:        10 * time.Millisecond,
+		Max:        30 * time.Millisecond,
+		Mean:       20 * time.Millisecond,
+		HeaderMin:  4 * time.Millisecond,
+		HeaderMax:  8 * time.Millisecond,
+		HeaderMean: 6 * time.Millisecond,
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetLatencyStatsFor_ExcludesIdlePeersWhenConfigured(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"peers": [
+			{"server": "10.0.0.1:80", "state": "up", "requests": 5, "header_time": 4, "response_time": 10},
+			{"server": "10.0.0.2:80", "state": "up", "requests": 0}
+		]
+	}`, "/api/8/http/upstreams/demo-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithIdlePeersExcluded())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.GetLatencyStatsFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.LatencyStats{
+		Min:        10 * time.Millisecond,
+		Max:        10 * time.Millisecond,
+		Mean:       10 * time.Millisecond,
+		HeaderMin:  4 * time.Millisecond,
+		HeaderMax:  4 * time.Millisecond,
+		HeaderMean: 4 * time.Millisecond,
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetLatencyStatsFor_FailsOnEmptyUpstream(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{"peers": []}`, "/api/8/http/upstreams/demo-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.GetLatencyStatsFor(context.Background(), "demo-backend")
+	if err == nil {
+		t.Fatal("want error on upstream without peers")
+	}
+}
+
+func TestGetPeersByDowntime_SortsPeersWithDowntimeDescending(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"peers": [
+			{"server": "10.0.0.1:80", "state": "up", "downtime": 1500},
+			{"server": "10.0.0.2:80", "state": "up", "downtime": 0},
+			{"server": "10.0.0.3:80", "state": "down", "downtime": 60000}
+		]
+	}`, "/api/8/http/upstreams/demo-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.GetPeersByDowntime(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []nginxhealthz.PeerDowntime{
+		{Server: "10.0.0.3:80", Downtime: time.Minute},
+		{Server: "10.0.0.1:80", Downtime: 1500 * time.Millisecond},
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetPeersByDowntime_ReturnsEmptyWithoutDowntime(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"peers": [
+			{"server": "10.0.0.1:80", "state": "up", "downtime": 0}
+		]
+	}`, "/api/8/http/upstreams/demo-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.GetPeersByDowntime(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || len(got) != 0 {
+		t.Errorf("want empty result, got %#v", got)
+	}
+}
+
+func TestGetStatsFor_HandlesMissingUpstream(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStatsFor(context.Background(), "new-backend"); err == nil {
+		t.Error("want error on missing upstream by default")
+	}
+
+	c, err = nginxhealthz.NewClient(ts.URL, nginxhealthz.WithMissingAsZero())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetStatsFor(context.Background(), "new-backend")
+	if err != nil {
+		t.Fatalf("want no error with WithMissingAsZero, got %v", err)
+	}
+	if !cmp.Equal(nginxhealthz.Stats{}, got) {
+		t.Error(cmp.Diff(nginxhealthz.Stats{}, got))
+	}
+}
+
+func TestGetResultsForUpstreams_FetchesLargeSetsInSingleCall(t *testing.T) {
+	t.Parallel()
+
+	var calls int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		verifyURIs("/api/8/http/upstreams?fields=peers", r.RequestURI, t)
+		io.WriteString(w, validResponseGetAllUpstreams)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithBatchThreshold(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := c.GetResultsForUpstreams(context.Background(), []string{"hg-backend", "lxr-backend", "missing-backend"})
+	if n := atomic.LoadInt64(&calls); n != 1 {
+		t.Errorf("want 1 API call, got %d", n)
+	}
+	want := []nginxhealthz.Stats{{Total: 2, Up: 1, Down: 1}, {Total: 2, Up: 2}, {}}
+	for i, r := range got {
+		if !cmp.Equal(want[i], r.Stats) {
+			t.Errorf("%s: %s", r.Name, cmp.Diff(want[i], r.Stats))
+		}
+	}
+	if got[0].Err != nil || got[1].Err != nil {
+		t.Errorf("want no errors for existing upstreams, got %v, %v", got[0].Err, got[1].Err)
+	}
+	if !errors.Is(got[2].Err, nginxhealthz.ErrUpstreamNotFound) {
+		t.Errorf("want ErrUpstreamNotFound for missing upstream, got %v", got[2].Err)
+	}
+}
+
+func TestGetResultsForUpstreams_FetchesSmallSetsIndividually(t *testing.T) {
+	t.Parallel()
+
+	var calls int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		io.WriteString(w, validResponseUpstreamHGbackend)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithBatchThreshold(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.GetResultsForUpstreams(context.Background(), []string{"hg-backend", "lxr-backend"})
+	if n := atomic.LoadInt64(&calls); n != 2 {
+		t.Errorf("want 2 API calls, got %d", n)
+	}
+}
+
+func TestGetResultsForUpstreams_AsksOnlyForPeers(t *testing.T) {
+	t.Parallel()
+
+	for _, upstreams := range [][]string{
+		{"hg-backend"},
+		{"hg-backend", "lxr-backend", "demo-backend"},
+	} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if got := r.URL.Query().Get("fields"); got != "peers" {
+				t.Errorf("%s: want fields=peers, got %q", r.URL.Path, r.URL.RawQuery)
+			}
+			io.WriteString(w, validResponseUpstreamHGbackend)
+		}))
+		c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithBatchThreshold(3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.GetResultsForUpstreams(context.Background(), upstreams)
+		ts.Close()
+	}
+}
+
+func TestGetSSLReuseRatioFor_ReturnsRatioPerPeer(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"peers": [
+			{"server": "10.0.0.1:443", "ssl": {"handshakes": 200, "session_reuses": 150}},
+			{"server": "10.0.0.2:443", "ssl": {"handshakes": 0, "session_reuses": 0}}
+		]
+	}`, "/api/8/http/upstreams/demo-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.GetSSLReuseRatioFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{"10.0.0.1:443": 0.75, "10.0.0.2:443": 0}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestWithPeerIdentity_KeysResultsByPeerName(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{
+			"peers": [
+				{"server": "10.0.0.1:443", "name": "app1.example.org:443", "downtime": 10, "ssl": {"handshakes": 4, "session_reuses": 1}}
+			]
+		}`)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithPeerIdentity(nginxhealthz.PeerIdentityName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ratios, err := c.GetSSLReuseRatioFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRatios := map[string]float64{"app1.example.org:443": 0.25}
+	if !cmp.Equal(wantRatios, ratios) {
+		t.Error(cmp.Diff(wantRatios, ratios))
+	}
+
+	downtime, err := c.GetPeersByDowntime(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(downtime) != 1 || downtime[0].Server != "app1.example.org:443" {
+		t.Errorf("want peer identified by name, got %+v", downtime)
+	}
+
+	health, err := c.GetHealthSummaryFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(health) != 1 || health[0].Server != "app1.example.org:443" {
+		t.Errorf("want peer identified by name, got %+v", health)
+	}
+}
+
+func TestNewClient_FailsOnInvalidPeerIdentity(t *testing.T) {
+	t.Parallel()
+
+	_, err := nginxhealthz.NewClient("http://localhost:9001", nginxhealthz.WithPeerIdentity(42))
+	if err == nil {
+		t.Fatal("want error on invalid peer identity")
+	}
+}
+
+func TestHeadroom_ReturnsPeersThatCanFailBeforeMinimum(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(validResponseGetUpstreamAllServersUp, "/api/8/http/upstreams/demo-backend?fields=peers", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		minUp, want int
+	}{
+		{minUp: 1, want: 1},
+		{minUp: 2, want: 0},
+		{minUp: 3, want: -1},
+	}
+	for _, tc := range tests {
+		got, err := c.Headroom(context.Background(), "demo-backend", tc.minUp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("minUp %d: want headroom %d, got %d", tc.minUp, tc.want, got)
+		}
+	}
+
+	if _, err := c.Headroom(context.Background(), "demo-backend", 0); err == nil {
+		t.Error("want error on minUp 0")
+	}
+}
+
+func TestGetHealthMatrix_GroupsUpstreamsByHost(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"hg-backend": {"zone": "bar.example.org-hg-backend", "peers": [{"state": "up"}, {"state": "down"}]},
+		"lxr-backend": {"zone": "bar.example.org-lxr-backend", "peers": [{"state": "up"}]},
+		"trac-backend": {"zone": "foo.example.com-trac-backend", "peers": [{"state": "up"}]},
+		"legacy": {"zone": "legacy", "peers": [{"state": "down"}]}
+	}`, "/api/8/http/upstreams", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.GetHealthMatrix(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string]nginxhealthz.Stats{
+		"bar.example.org": {
+			"hg-backend":  {Total: 2, Up: 1, Down: 1},
+			"lxr-backend": {Total: 1, Up: 1},
+		},
+		"foo.example.com": {
+			"trac-backend": {Total: 1, Up: 1},
+		},
+		nginxhealthz.UnknownHost: {
+			"legacy": {Total: 1, Down: 1},
+		},
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetStreamStatsFor_CallsStreamUpstreamsPath(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"zone": "bar.example.org-dns-backend",
+		"peers": [
+			{"server": "10.0.0.1:53", "state": "up"},
+			{"server": "10.0.0.2:53", "state": "down"}
+		]
+	}`, "/api/8/stream/upstreams/dns-backend?fields=peers", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.GetStreamStatsFor(context.Background(), "dns-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.Stats{Total: 2, Up: 1, Down: 1}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetStreamUpstreamsFor_CallsStreamZonesPath(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"dns-backend": {"zone": "bar.example.org-dns-backend"},
+		"mysql-backend": {"zone": "foo.example.com-mysql-backend"}
+	}`, "/api/8/stream/upstreams?fields=zone", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.GetStreamUpstreamsFor(context.Background(), "bar.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"bar.example.org": {"dns-backend"}}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetDetailedStatsFor_ReturnsStatsAndPeerDetails(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(validResponseUpstreamHGbackend, "/api/8/http/upstreams/hg-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.GetDetailedStatsFor(context.Background(), "hg-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.Stats{Total: 2, Up: 1, Down: 1}
+	if !cmp.Equal(want, got.Stats) {
+		t.Error(cmp.Diff(want, got.Stats))
+	}
+	if len(got.Peers) != 2 {
+		t.Fatalf("want 2 peers, got %d", len(got.Peers))
+	}
+	down := got.Peers[1]
+	if down.Server != "10.0.0.41:8084" || down.State != "down" || down.Downtime != 1012*time.Millisecond {
+		t.Errorf("unexpected peer details %+v", down)
+	}
+}
+
+func TestGetStatsFor_CountsEachPeerStateSeparately(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"peers": [
+			{"server": "10.0.0.1:80", "state": "up"},
+			{"server": "10.0.0.2:80", "state": "down"},
+			{"server": "10.0.0.3:80", "state": "unavail"},
+			{"server": "10.0.0.4:80", "state": "draining"},
+			{"server": "10.0.0.5:80", "state": "checking"},
+			{"server": "10.0.0.6:80", "state": "unhealthy"}
+		]
+	}`, "/api/8/http/upstreams/demo-backend?fields=peers", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.GetStatsFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.Stats{
+		Total:     6,
+		Up:        1,
+		Down:      1,
+		Unavail:   1,
+		Draining:  1,
+		Checking:  1,
+		Unhealthy: 1,
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetStatsForUpstreams_SumsEachPeerState(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"peers": [{"state": "up"}, {"state": "draining"}, {"state": "unavail"}]}`)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.GetStatsForUpstreams(context.Background(), []string{"hg-backend", "lxr-backend"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.Stats{Total: 6, Up: 2, Draining: 2, Unavail: 2}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetStatsFor_RetriesTransientFailures(t *testing.T) {
+	t.Parallel()
+
+	var calls int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&calls, 1) <= 2 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		io.WriteString(w, validResponseGetUpstreamAllServersUp)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithRetries(3, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.GetStatsFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.Stats{Total: 2, Up: 2}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	if n := atomic.LoadInt64(&calls); n != 3 {
+		t.Errorf("want 3 calls, got %d", n)
+	}
+}
+
+func TestGetStatsFor_DoesNotRetryClientErrors(t *testing.T) {
+	t.Parallel()
+
+	var calls int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithRetries(3, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.GetStatsFor(context.Background(), "demo-backend"); err == nil {
+		t.Fatal("want error")
+	}
+	if n := atomic.LoadInt64(&calls); n != 1 {
+		t.Errorf("want 1 call, got %d", n)
+	}
+}
+
+func TestGetStatsFor_StopsRetryingWhenContextIsCancelled(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithRetries(5, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.GetStatsFor(ctx, "demo-backend"); err == nil {
+		t.Fatal("want error")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("retry loop ignored context cancellation")
+	}
+}
+
+func TestGetStatsFor_ReturnsTypedErrorForStatusCode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		code int
+		want error
+	}{
+		{code: http.StatusNotFound, want: nginxhealthz.ErrUpstreamNotFound},
+		{code: http.StatusUnauthorized, want: nginxhealthz.ErrUnauthorized},
+		{code: http.StatusForbidden, want: nginxhealthz.ErrUnauthorized},
+	}
+	for _, tc := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tc.code)
+		}))
+		c, err := nginxhealthz.NewClient(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.GetStatsFor(context.Background(), "demo-backnd")
+		ts.Close()
+		if !errors.Is(err, tc.want) {
+			t.Errorf("code %d: want %v, got %v", tc.code, tc.want, err)
+		}
+	}
+}
+
+func TestGetStatsFor_ReturnsGenericErrorForOtherStatusCodes(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.GetStatsFor(context.Background(), "demo-backend")
+	if err == nil {
+		t.Fatal("want error")
+	}
+	if errors.Is(err, nginxhealthz.ErrUpstreamNotFound) || errors.Is(err, nginxhealthz.ErrUnauthorized) {
+		t.Errorf("want generic status error, got %v", err)
+	}
+}
+
+func TestGetStatsFor_ReturnsAPIErrorWithNGINXErrorDetails(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, responseUpstreamNotFoundError)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.GetStatsFor(context.Background(), "demo-backnd")
+	var apiErr *nginxhealthz.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("want APIError, got %v", err)
+	}
+	want := nginxhealthz.APIError{
+		StatusCode: http.StatusNotFound,
+		Code:       "UpstreamNotFound",
+		Text:       "upstream not found",
+		RequestID:  "d5d6bc1f4a3a2ab6d2a4d3b3d8b9b3e1",
+	}
+	if !cmp.Equal(want, *apiErr, cmpopts.IgnoreUnexported(nginxhealthz.APIError{})) {
+		t.Error(cmp.Diff(want, *apiErr, cmpopts.IgnoreUnexported(nginxhealthz.APIError{})))
+	}
+	for _, s := range []string{"404", "UpstreamNotFound", "upstream not found", "d5d6bc1f4a3a2ab6d2a4d3b3d8b9b3e1"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("error %q does not mention %q", err, s)
+		}
+	}
+	if !errors.Is(err, nginxhealthz.ErrUpstreamNotFound) {
+		t.Errorf("want ErrUpstreamNotFound, got %v", err)
+	}
+}
+
+func TestGetStatsFor_ReturnsAPIErrorWithStatusOnlyForNonJSONBody(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		io.WriteString(w, "<html><body>Bad Gateway</body></html>")
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.GetStatsFor(context.Background(), "demo-backend")
+	var apiErr *nginxhealthz.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("want APIError, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusBadGateway || apiErr.Code != "" || apiErr.Text != "" || apiErr.RequestID != "" {
+		t.Errorf("want only the status code set, got %+v", apiErr)
+	}
+}
+
+func TestGetStatsFor_SendsBasicAuthCredentials(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "admin" || pass != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, validResponseGetUpstreamAllServersUp)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithBasicAuth("admin", "s3cret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStatsFor(context.Background(), "demo-backend"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetStatsFor_OmitsAuthorizationHeaderByDefault(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h := r.Header.Get("Authorization"); h != "" {
+			t.Errorf("want no Authorization header, got %q", h)
+		}
+		io.WriteString(w, validResponseGetUpstreamAllServersUp)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStatsFor(context.Background(), "demo-backend"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetStatsForUpstreams_SendsCustomHeadersOnEveryRequest(t *testing.T) {
+	t.Parallel()
+
+	var requests int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		if got := r.Header.Get("Authorization"); got != "Bearer t0ken" {
+			t.Errorf("want bearer token, got %q", got)
+		}
+		if got := r.Header.Get("X-API-Key"); got != "k3y" {
+			t.Errorf("want API key, got %q", got)
+		}
+		if got := r.Header.Get("X-Tenant"); got != "acme" {
+			t.Errorf("want tenant header, got %q", got)
+		}
+		io.WriteString(w, validResponseGetUpstreamAllServersUp)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL,
+		nginxhealthz.WithBearerToken("t0ken"),
+		nginxhealthz.WithHeader("X-API-Key", "k3y"),
+		nginxhealthz.WithHeader("X-Tenant", "acme"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStatsForUpstreams(context.Background(), []string{"a-backend", "b-backend", "c-backend"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&requests); n != 3 {
+		t.Errorf("want 3 requests, got %d", n)
+	}
+}
+
+func TestGetStatsFor_FailsWhenRequestExceedsTimeout(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithTimeout(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := c.GetStatsFor(context.Background(), "demo-backend"); err == nil {
+		t.Fatal("want timeout error")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("request did not time out")
+	}
+}
+
+func TestNewClient_FailsOnInvalidTimeout(t *testing.T) {
+	t.Parallel()
+
+	if _, err := nginxhealthz.NewClient("http://localhost:9001", nginxhealthz.WithTimeout(0)); err == nil {
+		t.Fatal("want error")
+	}
+}
+
+func TestGetStatsForUpstreams_RespectsMaxConcurrency(t *testing.T) {
+	t.Parallel()
+
+	const limit = 3
+	var inFlight, maxInFlight int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+		for {
+			m := atomic.LoadInt64(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt64(&maxInFlight, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		io.WriteString(w, validResponseGetUpstreamAllServersUp)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL,
+		nginxhealthz.WithMaxConcurrency(limit),
+		nginxhealthz.WithBatchThreshold(0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstreams := make([]string, 12)
+	for i := range upstreams {
+		upstreams[i] = fmt.Sprintf("backend-%d", i)
+	}
+	got, err := c.GetStatsForUpstreams(context.Background(), upstreams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := nginxhealthz.Stats{Total: 24, Up: 24}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	if m := atomic.LoadInt64(&maxInFlight); m > limit {
+		t.Errorf("want at most %d concurrent requests, got %d", limit, m)
+	}
+}
+
+func TestGetStatsForUpstreams_ReturnsErrorsOfFailingUpstreams(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/missing-backend") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, validResponseUpstreamHGbackend)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetStatsForUpstreams(context.Background(), []string{"hg-backend", "missing-backend"})
+	if !errors.Is(err, nginxhealthz.ErrUpstreamNotFound) {
+		t.Errorf("want upstream not found error, got %v", err)
+	}
+	if err != nil && !strings.Contains(err.Error(), "missing-backend") {
+		t.Errorf("want error naming the upstream, got %v", err)
+	}
+	want := nginxhealthz.Stats{Total: 2, Up: 1, Down: 1}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetStatsForUpstreams_CountsUpstreamWithoutPeersAsEmpty(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/empty-backend") {
+			io.WriteString(w, `{"peers": [], "zone": "bar.example.org-empty-backend"}`)
+			return
+		}
+		io.WriteString(w, validResponseGetUpstreamAllServersUp)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetStatsForUpstreams(context.Background(), []string{"demo-backend", "empty-backend"})
+	if err != nil {
+		t.Fatalf("want no error for an empty upstream, got %v", err)
+	}
+	want := nginxhealthz.Stats{Total: 2, Up: 2, Empty: 1}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	if got.Healthy(1) || (nginxhealthz.HealthPolicy{}).Healthy(got) {
+		t.Error("want stats counting an empty upstream unhealthy")
+	}
+}
+
+func TestGetStatsFor_FailsWithErrNoPeersOnUpstreamWithoutPeers(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{"peers": []}`, "/api/8/http/upstreams/demo-backend?fields=peers", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.GetStatsFor(context.Background(), "demo-backend")
+	if !errors.Is(err, nginxhealthz.ErrNoPeers) {
+		t.Errorf("want ErrNoPeers, got %v", err)
+	}
+}
+
+func TestGetLatencyStatsFor_FailsWithErrNoPeersOnUpstreamWithoutPeers(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{"peers": []}`, "/api/8/http/upstreams/demo-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.GetLatencyStatsFor(context.Background(), "demo-backend")
+	if !errors.Is(err, nginxhealthz.ErrNoPeers) {
+		t.Errorf("want ErrNoPeers, got %v", err)
+	}
+}
+
+func TestDiscoverVersion_ReturnsHighestListedVersion(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `[4,5,6,7]`)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.DiscoverVersion(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 7 {
+		t.Errorf("want version 7, got %d", got)
+	}
+	if v := c.Version(); v != 8 {
+		t.Errorf("want client version unchanged, got %d", v)
+	}
+}
+
+func TestWithAutoVersion_DiscoversVersionOnFirstRequest(t *testing.T) {
+	t.Parallel()
+
+	var discoveries int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/":
+			atomic.AddInt64(&discoveries, 1)
+			io.WriteString(w, `[4,5,6,7,8,9]`)
+		case "/api/9/http/upstreams/demo-backend":
+			io.WriteString(w, validResponseGetUpstreamAllServersUp)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithAutoVersion())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&discoveries); n != 0 {
+		t.Fatalf("want lazy discovery, got %d discoveries", n)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetStatsFor(context.Background(), "demo-backend"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt64(&discoveries); n != 1 {
+		t.Errorf("want 1 discovery, got %d", n)
+	}
+	if v := c.Version(); v != 9 {
+		t.Errorf("want version 9, got %d", v)
+	}
+}
+
+func TestWithAutoVersion_KeepsDefaultVersionWhenDiscoveryFails(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		io.WriteString(w, validResponseGetUpstreamAllServersUp)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithAutoVersion())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStatsFor(context.Background(), "demo-backend"); err != nil {
+		t.Fatal(err)
+	}
+	if v := c.Version(); v != 8 {
+		t.Errorf("want default version 8, got %d", v)
+	}
+}
+
+func TestGetStatsFor_EscapesUpstreamName(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(validResponseGetUpstreamAllServersUp, "/api/8/http/upstreams/100%25%2F..%2Fnginx?fields=peers", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStatsFor(context.Background(), "100%/../nginx"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetStatsFor_FailsOnEmptyUpstreamName(t *testing.T) {
+	t.Parallel()
+
+	c, err := nginxhealthz.NewClient("http://localhost:9001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStatsFor(context.Background(), ""); err == nil {
+		t.Fatal("want error")
+	}
+}
+
+const validResponseUpstreamWithBackupPeers = `{
+  "peers": [
+    {"id": 0, "server": "10.0.0.1:80", "state": "up", "backup": false},
+    {"id": 1, "server": "10.0.0.2:80", "state": "down", "backup": false},
+    {"id": 2, "server": "10.0.0.3:80", "state": "up", "backup": true}
+  ]
+}`
+
+func TestGetStatsFor_CountsBackupPeers(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(validResponseUpstreamWithBackupPeers, "/api/8/http/upstreams/demo-backend?fields=peers", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetStatsFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.Stats{Total: 3, Up: 2, Down: 1, Backup: 1}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetStatsFor_ExcludesBackupPeersWhenConfigured(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(validResponseUpstreamWithBackupPeers, "/api/8/http/upstreams/demo-backend?fields=peers", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithBackupExcluded())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetStatsFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.Stats{Total: 2, Up: 1, Down: 1, Backup: 1}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetStatsFor_CountsDrainingPeersSeparatelyByDefault(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(responseUpstreamWithDrainingPeer, "/api/8/http/upstreams/demo-backend?fields=peers", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetStatsFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.Stats{Total: 3, Up: 1, Down: 1, Draining: 1}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetStatsFor_CountsDrainingPeersAsUpWhenConfigured(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(responseUpstreamWithDrainingPeer, "/api/8/http/upstreams/demo-backend?fields=peers", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithDrainingCountsAsUp())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetStatsFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.Stats{Total: 3, Up: 2, Down: 1}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetStatsForUpstreams_SendsUserAgent(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("User-Agent"); got != "probe/1.0" {
+			t.Errorf("want user agent %q, got %q", "probe/1.0", got)
+		}
+		io.WriteString(w, validResponseGetUpstreamAllServersUp)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithUserAgent("probe/1.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStatsForUpstreams(context.Background(), []string{"a-backend", "b-backend"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetStatsFor_SendsDefaultUserAgent(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("User-Agent"); !strings.HasPrefix(got, "nginx-healthz/") {
+			t.Errorf("want default user agent, got %q", got)
+		}
+		io.WriteString(w, validResponseGetUpstreamAllServersUp)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStatsFor(context.Background(), "demo-backend"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetAllUpstreamsFor_MergesHTTPAndStreamUpstreams(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/8/http/upstreams":
+			io.WriteString(w, `{"hg-backend": {"zone": "bar.example.org-hg-backend"}}`)
+		case "/api/8/stream/upstreams":
+			io.WriteString(w, `{"mysql-backend": {"zone": "bar.example.org-mysql-backend"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetAllUpstreamsFor(context.Background(), "bar.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]nginxhealthz.Upstream{
+		"bar.example.org": {
+			{Name: "hg-backend", Protocol: nginxhealthz.ProtocolHTTP},
+			{Name: "mysql-backend", Protocol: nginxhealthz.ProtocolStream},
+		},
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetAllUpstreamsFor_ToleratesMissingStreamEndpoint(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/8/http/upstreams" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, `{"hg-backend": {"zone": "bar.example.org-hg-backend"}}`)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetAllUpstreamsFor(context.Background(), "bar.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]nginxhealthz.Upstream{
+		"bar.example.org": {{Name: "hg-backend", Protocol: nginxhealthz.ProtocolHTTP}},
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetAllUpstreamsFor_QueriesHTTPAndStreamConcurrently(t *testing.T) {
+	t.Parallel()
+
+	// Each listing responds only once both are in flight,
+	// which never happens when they are queried in turn.
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	both := make(chan struct{})
+	go func() {
+		arrived.Wait()
+		close(both)
+	}()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived.Done()
+		select {
+		case <-both:
+		case <-time.After(5 * time.Second):
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/8/http/upstreams":
+			io.WriteString(w, `{"hg-backend": {"zone": "bar.example.org-hg-backend"}}`)
+		default:
+			io.WriteString(w, `{"mysql-backend": {"zone": "bar.example.org-mysql-backend"}}`)
+		}
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetAllUpstreamsFor(context.Background(), "bar.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got["bar.example.org"]) != 2 {
+		t.Errorf("want HTTP and stream upstreams, got %v", got)
+	}
+}
+
+func TestGetAllUpstreamsFor_FailsWhenListingFails(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/8/stream/upstreams" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		io.WriteString(w, `{"hg-backend": {"zone": "bar.example.org-hg-backend"}}`)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetAllUpstreamsFor(context.Background(), "bar.example.org"); err == nil {
+		t.Error("want error, got nil")
+	}
+}
+
+func TestGetStatsForHost_IncludesStreamUpstreams(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/8/http/upstreams":
+			io.WriteString(w, `{"hg-backend": {"zone": "bar.example.org-hg-backend"}}`)
+		case "/api/8/stream/upstreams":
+			io.WriteString(w, `{"mysql-backend": {"zone": "bar.example.org-mysql-backend"}}`)
+		case "/api/8/http/upstreams/hg-backend":
+			io.WriteString(w, validResponseUpstreamHGbackend)
+		case "/api/8/stream/upstreams/mysql-backend":
+			io.WriteString(w, `{"peers": [{"server": "10.0.0.9:3306", "state": "up"}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetStatsForHost(context.Background(), "bar.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.Stats{Total: 3, Up: 2, Down: 1}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetUpstreamsFor_UsesZoneParser(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		zones string
+		parse func(string) string
+	}{
+		{
+			name:  "upstream.hostname.tier",
+			zones: `{"hg-backend": {"zone": "hg-backend.bar.example.org.prod"}, "lxr-backend": {"zone": "lxr-backend.foo.example.org.prod"}}`,
+			parse: func(zone string) string {
+				_, rest, ok := strings.Cut(zone, ".")
+				if !ok {
+					return ""
+				}
+				return strings.TrimSuffix(rest, ".prod")
+			},
+		},
+		{
+			name:  "hostname:upstream",
+			zones: `{"hg-backend": {"zone": "bar.example.org:hg-backend"}, "lxr-backend": {"zone": "foo.example.org:lxr-backend"}}`,
+			parse: func(zone string) string {
+				host, _, _ := strings.Cut(zone, ":")
+				return host
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newTestServerWithPathValidator(tc.zones, "/api/8/http/upstreams?fields=zone", t)
+			defer ts.Close()
+
+			c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithZoneParser(tc.parse))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.GetUpstreamsFor(context.Background(), "bar.example.org")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string][]string{"bar.example.org": {"hg-backend"}}
+			if !cmp.Equal(want, got) {
+				t.Error(cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+func TestGetUpstreamsFor_MatchesHostnameContainingDash(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"hg-backend": {"zone": "bar-foo.example.org-hg-backend"},
+		"lxr-backend": {"zone": "bar.example.org-lxr-backend"}
+	}`, "/api/8/http/upstreams?fields=zone", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetUpstreamsFor(context.Background(), "bar-foo.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"bar-foo.example.org": {"hg-backend"}}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetStatsFor_LogsRequestsAtDebugLevel(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(validResponseGetUpstreamAllServersUp, "/api/8/http/upstreams/demo-backend?fields=peers", t)
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStatsFor(context.Background(), "demo-backend"); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	for _, want := range []string{"level=DEBUG", "method=GET", `url="` + ts.URL + `/api/8/http/upstreams/demo-backend?fields=peers"`, "status=200", "duration="} {
+		if !strings.Contains(got, want) {
+			t.Errorf("want %q in logs, got %q", want, got)
+		}
+	}
+}
+
+func TestGetStatsForUpstreams_LogsFailingUpstreams(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithLogger(slog.New(slog.NewTextHandler(&buf, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStatsForUpstreams(context.Background(), []string{"missing-backend"}); err == nil {
+		t.Fatal("want error")
+	}
+	got := buf.String()
+	if !strings.Contains(got, "level=WARN") || !strings.Contains(got, "upstream=missing-backend") {
+		t.Errorf("want warning naming the upstream, got %q", got)
+	}
+}
+
+func TestGetResponseCodesFor_SumsAllCodesAcrossPeers(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"peers": [
+			{"server": "10.0.0.1:80", "state": "up", "responses": {
+				"2xx": 10, "5xx": 3, "codes": {"200": 10, "502": 2, "503": 1}, "total": 13}},
+			{"server": "10.0.0.2:80", "state": "up", "responses": {
+				"2xx": 5, "4xx": 1, "5xx": 1, "codes": {"200": 5, "429": 1, "502": 1}, "total": 7}}
+		]
+	}`, "/api/8/http/upstreams/demo-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetResponseCodesFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{
+		"200": 15, "429": 1, "502": 3, "503": 1,
+		"1xx": 0, "2xx": 15, "3xx": 0, "4xx": 1, "5xx": 4,
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetStatsForAllUpstreams_SumsEveryUpstream(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetStatsForAllUpstreams(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fake serves hg-backend for demo-backend and trac-backend too.
+	want := nginxhealthz.Stats{Total: 8, Up: 5, Down: 3}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetStatsForAllUpstreams_ReturnsZeroStatsForEmptyInstance(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{}`, "/api/8/http/upstreams?fields=zone", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetStatsForAllUpstreams(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(nginxhealthz.Stats{}, got) {
+		t.Error(cmp.Diff(nginxhealthz.Stats{}, got))
+	}
+}
+
+func TestGetStatsFor_WaitsForRetryAfterOnServiceUnavailable(t *testing.T) {
+	t.Parallel()
+
+	var calls int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, validResponseGetUpstreamAllServersUp)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithRetries(1, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := c.GetStatsFor(context.Background(), "demo-backend"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 2*time.Second {
+		t.Errorf("want retry after at least 2s, got %v", elapsed)
+	}
+}
+
+func TestGetStatsFor_RetryAfterIsCappedByContextDeadline(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithRetries(1, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.GetStatsFor(ctx, "demo-backend"); err == nil {
+		t.Fatal("want error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("want wait capped by context deadline, got %v", elapsed)
+	}
+}
+
+func TestWithDefaultRequestTimeout_BoundsCallsWithoutDeadline(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	c, err := nginxhealthz.NewClient(ts.URL,
+		nginxhealthz.WithHTTPClient(&http.Client{}),
+		nginxhealthz.WithDefaultRequestTimeout(20*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_, err = c.GetStatsFor(context.Background(), "demo-backend")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("call was not bounded, took %v", elapsed)
+	}
+}
+
+func TestWithDefaultRequestTimeout_KeepsCallerDeadline(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		io.WriteString(w, validResponseGetUpstreamAllServersUp)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithDefaultRequestTimeout(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := c.GetStatsFor(ctx, "demo-backend"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestServerListingVersions(versions string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, versions)
+	}))
+}
+
+func TestPing_SucceedsWhenVersionIsSupported(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerListingVersions("[1,2,3,4,5,6,7,8,9]")
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPing_FailsNamingSupportedVersionsWhenVersionIsUnsupported(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerListingVersions("[4,5,6]")
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithVersion(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Ping(context.Background())
+	if !errors.Is(err, nginxhealthz.ErrUnsupportedVersion) {
+		t.Fatalf("want ErrUnsupportedVersion, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "[4 5 6]") {
+		t.Errorf("want error naming supported versions, got %q", err)
+	}
+}
+
+func TestPing_FailsWhenAPIIsUnreachable(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Ping(context.Background())
+	if err == nil || errors.Is(err, nginxhealthz.ErrUnsupportedVersion) {
+		t.Fatalf("want connection error, got %v", err)
+	}
+}
+
+func TestGetStatsFor_SharesConcurrentIdenticalRequests(t *testing.T) {
+	t.Parallel()
+
+	var hits int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		<-release
+		io.WriteString(w, validResponseGetUpstreamAllServersUp)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 10
+	var joined sync.WaitGroup
+	joined.Add(n)
+	nginxhealthz.SetSharedJoinedHook(c, joined.Done)
+
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			stats, err := c.GetStatsFor(context.Background(), "demo-backend")
+			if err == nil && stats.Up != 2 {
+				err = fmt.Errorf("want 2 peers up, got %+v", stats)
+			}
+			errs <- err
+		}()
+	}
+	joined.Wait()
+	close(release)
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if got := atomic.LoadInt32(&hits); got != 1 {
+		t.Errorf("want 1 request, got %d", got)
+	}
+}
+
+func TestGetStatsFor_CancellingOneCallerDoesNotFailSharedRequest(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, validResponseGetUpstreamAllServersUp)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined := make(chan struct{}, 2)
+	nginxhealthz.SetSharedJoinedHook(c, func() { joined <- struct{}{} })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := c.GetStatsFor(ctx, "demo-backend")
+		first <- err
+	}()
+	<-started
+	<-joined
+	second := make(chan error, 1)
+	go func() {
+		_, err := c.GetStatsFor(context.Background(), "demo-backend")
+		second <- err
+	}()
+	<-joined
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled for the cancelled caller, got %v", err)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Errorf("want no error for the other caller, got %v", err)
+	}
+}
+
+func newTestServerSupportingVersion(version string, t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix := "/api/" + version + "/"
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Path == prefix {
+			io.WriteString(w, `["nginx","http"]`)
+			return
+		}
+		io.WriteString(w, validResponseGetUpstreamAllServersUp)
+	}))
+}
+
+func TestGetStatsFor_FallsBackToLowerVersionWhenEnabled(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerSupportingVersion("6", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithVersionFallback())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.GetStatsFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.Stats{Total: 2, Up: 2, Down: 0}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	if c.Version() != 6 {
+		t.Errorf("want version 6 after fallback, got %d", c.Version())
+	}
+}
+
+func TestGetStatsFor_FailsOnUnsupportedVersionWithoutFallback(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerSupportingVersion("6", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.GetStatsFor(context.Background(), "demo-backend")
+	if err == nil {
+		t.Fatal("want error on unsupported version")
+	}
+	if c.Version() != 8 {
+		t.Errorf("want version 8, got %d", c.Version())
+	}
+}
+
+var (
+	validResponseGetAllUpstreams = `{
+		"hg-backend": ` + validResponseUpstreamHGbackend + `,
+		"lxr-backend": ` + validResponseUpstreamLXRbackend + `
+	}`
+
+	validResponseUpstreamLXRbackend = `{
+		"peers": [
+			{
+				"id": 0,
+				"server": "10.0.0.42:8084",
+				"name": "10.0.0.42:8084",
+				"backup": false,
+				"weight": 1,
+				"state": "up",
+				"active": 1,
+				"ssl": {
+					"handshakes": 19803694,
+					"handshakes_failed": 0,
+					"session_reuses": 19803274
+				},
+				"requests": 19803806,
+				"header_time": 10,
+				"response_time": 10,
+				"responses": {
+					"1xx": 0,
+					"2xx": 17210560,
+					"3xx": 8364,
+					"4xx": 1721,
+					"5xx": 0,
+					"codes": {
+						"200": 17210560,
+						"301": 7922,
+						"304": 442,
+						"400": 1,
+						"404": 1086,
+						"405": 634
+					},
+					"total": 17220645
+				},
+				"sent": 7525574329,
+				"received": 16975650045,
+				"fails": 2583043,
+				"unavail": 0,
+				"health_checks": {
+					"checks": 2852546,
+					"fails": 0,
+					"unhealthy": 0,
+					"last_passed": true
+				},
+				"downtime": 0,
+				"selected": "2022-10-17T20:38:40Z"
+			},
+			{
+				"id": 1,
+				"server": "10.0.0.41:8084",
+				"name": "10.0.0.41:8084",
+				"backup": false,
+				"weight": 1,
+				"state": "up",
+				"active": 0,
+				"ssl": {
+					"handshakes": 21808054,
+					"handshakes_failed": 0,
+					"session_reuses": 21807458
+				},
+				"requests": 21808225,
+				"header_time": 11,
+				"response_time": 11,
+				"responses": {
+					"1xx": 0,
+					"2xx": 19223635,
+					"3xx": 275,
+					"4xx": 1089,
+					"5xx": 0,
+					"codes": {
+						"200": 19223634,
+						"206": 1,
+						"301": 100,
+						"304": 175,
+						"404": 623,
+						"405": 466
+					},
+					"total": 19224999
+				},
+				"sent": 10110237497,
+				"received": 18490496811,
+				"fails": 2583040,
+				"unavail": 0,
+				"health_checks": {
+					"checks": 2842133,
+					"fails": 1,
+					"unhealthy": 1,
+					"last_passed": true
+				},
+				"downtime": 1012,
+				"selected": "2022-10-17T20:38:35Z"
+			}
+		],
+		"keepalive": 0,
+		"zombies": 0,
+		"zone": "bar.example.org-lxr-backend"
+	}`
+
+	validResponseUpstreamHGbackend = `{
+		"peers": [
+			{
+				"id": 0,
+				"server": "10.0.0.42:8084",
+				"name": "10.0.0.42:8084",
+				"backup": false,
+				"weight": 1,
+				"state": "up",
+				"active": 1,
+				"ssl": {
+					"handshakes": 19803694,
+					"handshakes_failed": 0,
+					"session_reuses": 19803274
+				},
+				"requests": 19803806,
+				"header_time": 10,
+				"response_time": 10,
+				"responses": {
+					"1xx": 0,
+					"2xx": 17210560,
+					"3xx": 8364,
+					"4xx": 1721,
+					"5xx": 0,
+					"codes": {
+						"200": 17210560,
+						"301": 7922,
+						"304": 442,
+						"400": 1,
+						"404": 1086,
+						"405": 634
+					},
+					"total": 17220645
+				},
+				"sent": 7525574329,
+				"received": 16975650045,
+				"fails": 2583043,
+				"unavail": 0,
+				"health_checks": {
+					"checks": 2852546,
+					"fails": 0,
+					"unhealthy": 0,
+					"last_passed": true
+				},
+				"downtime": 0,
+				"selected": "2022-10-17T20:38:40Z"
+			},
+			{
+				"id": 1,
+				"server": "10.0.0.41:8084",
+				"name": "10.0.0.41:8084",
+				"backup": false,
+				"weight": 1,
+				"state": "down",
+				"active": 0,
+				"ssl": {
+					"handshakes": 21808054,
+					"handshakes_failed": 0,
+					"session_reuses": 21807458
+				},
+				"requests": 21808225,
+				"header_time": 11,
+				"response_time": 11,
+				"responses": {
+					"1xx": 0,
+					"2xx": 19223635,
+					"3xx": 275,
+					"4xx": 1089,
+					"5xx": 0,
+					"codes": {
+						"200": 19223634,
+						"206": 1,
+						"301": 100,
+						"304": 175,
+						"404": 623,
+						"405": 466
+					},
+					"total": 19224999
+				},
+				"sent": 10110237497,
+				"received": 18490496811,
+				"fails": 2583040,
+				"unavail": 0,
+				"health_checks": {
+					"checks": 2842133,
+					"fails": 1,
+					"unhealthy": 1,
+					"last_passed": true
+				},
+				"downtime": 1012,
+				"selected": "2022-10-17T20:38:35Z"
+			}
+		],
+		"keepalive": 0,
+		"zombies": 0,
+		"zone": "bar.example.org-hg-backend"
+	}`
+
+	validResponseGetUpstreamAllServersUp = `{
+		"peers": [
+			{
+				"id": 0,
+				"server": "10.0.0.42:8084",
+				"name": "10.0.0.42:8084",
+				"backup": false,
+				"weight": 1,
+				"state": "up",
+				"active": 1,
+				"ssl": {
+					"handshakes": 19803694,
+					"handshakes_failed": 0,
+					"session_reuses": 19803274
+				},
+				"requests": 19803806,
+				"header_time": 10,
+				"response_time": 10,
+				"responses": {
+					"1xx": 0,
+					"2xx": 17210560,
+					"3xx": 8364,
+					"4xx": 1721,
+					"5xx": 0,
+					"codes": {
+						"200": 17210560,
+						"301": 7922,
+						"304": 442,
+						"400": 1,
+						"404": 1086,
+						"405": 634
+					},
+					"total": 17220645
+				},
+				"sent": 7525574329,
+				"received": 16975650045,
+				"fails": 2583043,
+				"unavail": 0,
+				"health_checks": {
+					"checks": 2852546,
+					"fails": 0,
+					"unhealthy": 0,
+					"last_passed": true
+				},
+				"downtime": 0,
+				"selected": "2022-10-17T20:38:40Z"
+			},
+			{
+				"id": 1,
+				"server": "10.0.0.41:8084",
+				"name": "10.0.0.41:8084",
+				"backup": false,
+				"weight": 1,
+				"state": "up",
+				"active": 0,
+				"ssl": {
+					"handshakes": 21808054,
+					"handshakes_failed": 0,
+					"session_reuses": 21807458
+				},
+				"requests": 21808225,
+				"header_time": 11,
+				"response_time": 11,
+				"responses": {
+					"1xx": 0,
+					"2xx": 19223635,
+					"3xx": 275,
+					"4xx": 1089,
+					"5xx": 0,
+					"codes": {
+						"200": 19223634,
+						"206": 1,
+						"301": 100,
+						"304": 175,
+						"404": 623,
+						"405": 466
+					},
+					"total": 19224999
+				},
+				"sent": 10110237497,
+				"received": 18490496811,
+				"fails": 2583040,
+				"unavail": 0,
+				"health_checks": {
+					"checks": 2842133,
+					"fails": 1,
+					"unhealthy": 1,
+					"last_passed": true
+				},
+				"downtime": 1012,
+				"selected": "2022-10-17T20:38:35Z"
+			}
+		],
+		"keepalive": 0,
+		"zombies": 0,
+		"zone": "demo-backend"
+	}`
+
+	responseUpstreamWithDrainingPeer = `{
+		"peers": [
+			{"id": 0, "server": "10.0.0.1:80", "state": "up"},
+			{"id": 1, "server": "10.0.0.2:80", "state": "draining"},
+			{"id": 2, "server": "10.0.0.3:80", "state": "down"}
+		],
+		"zone": "bar.example.org-demo-backend"
+	}`
+
+	responseUpstreamNotFoundError = `{
+		"error": {
+			"status": 404,
+			"text": "upstream not found",
+			"code": "UpstreamNotFound"
+		},
+		"request_id": "d5d6bc1f4a3a2ab6d2a4d3b3d8b9b3e1",
+		"href": "https://nginx.org/en/docs/http/ngx_http_api_module.html"
+	}`
+
+	validResponseGetUpstreamsZones = `{
+		"demo-backend": {
+			"zone": "foo.example.com-demo-backend"
+		},
+		"trac-backend": {
+			"zone": "bar.example.com-trac-backend"
+		},
+		"hg-backend": {
+			"zone": "bar.example.org-hg-backend"
+		},
+		"lxr-backend": {
+			"zone": "bar.example.org-lxr-backend"
+		}
+	}`
+)
+
+func TestPeerHostPort_SplitsIPv4AndIPv6Addresses(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		server, host, port string
+	}{
+		{server: "10.0.0.1:8080", host: "10.0.0.1", port: "8080"},
+		{server: "[2001:db8::1]:8080", host: "2001:db8::1", port: "8080"},
+		{server: "backend.example.org:443", host: "backend.example.org", port: "443"},
+	}
+	for _, tt := range tests {
+		host, port, err := nginxhealthz.Peer{Server: tt.server}.HostPort()
+		if err != nil {
+			t.Errorf("%s: %v", tt.server, err)
+			continue
+		}
+		if host != tt.host || port != tt.port {
+			t.Errorf("%s: want %q %q, got %q %q", tt.server, tt.host, tt.port, host, port)
+		}
+	}
+}
+
+func TestPeerHostPort_FailsOnAddressWithoutPort(t *testing.T) {
+	t.Parallel()
+
+	if _, _, err := (nginxhealthz.Peer{Server: "2001:db8::1"}).HostPort(); err == nil {
+		t.Error("want error, got nil")
+	}
+}
+
+func TestWithProtocol_SelectsUpstreamPathPrefix(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		protocol nginxhealthz.Protocol
+		wantURI  string
+	}{
+		{nginxhealthz.ProtocolHTTP, "/api/8/http/upstreams/demo-backend?fields=peers"},
+		{nginxhealthz.ProtocolStream, "/api/8/stream/upstreams/demo-backend?fields=peers"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.protocol.String(), func(t *testing.T) {
+			t.Parallel()
+
+			ts := newTestServerWithPathValidator(validResponseGetUpstreamAllServersUp, tt.wantURI, t)
+			defer ts.Close()
+
+			c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithProtocol(tt.protocol))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.GetStatsFor(context.Background(), "demo-backend"); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestWithProtocol_SelectsPathOfAllUpstreams(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(validResponseGetUpstreamsZones, "/api/8/stream/upstreams", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithProtocol(nginxhealthz.ProtocolStream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetAllStats(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewClient_FailsOnUnsupportedProtocol(t *testing.T) {
+	t.Parallel()
+
+	if _, err := nginxhealthz.NewClient("http://localhost:9001", nginxhealthz.WithProtocol(nginxhealthz.Protocol(7))); err == nil {
+		t.Error("want error, got nil")
+	}
+}
+
+func TestGetStatsSnapshotFor_ReturnsStatsWithSourceAndCollectionTime(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(validResponseGetUpstreamAllServersUp, "/api/8/http/upstreams/demo-backend?fields=peers", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now()
+	got, err := c.GetStatsSnapshotFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Source != "demo-backend" {
+		t.Errorf("want source demo-backend, got %q", got.Source)
+	}
+	want := nginxhealthz.Stats{Total: 2, Up: 2}
+	if got.Stats != want {
+		t.Errorf("want %+v, got %+v", want, got.Stats)
+	}
+	if got.CollectedAt.Before(before) || got.CollectedAt.After(time.Now()) {
+		t.Errorf("want collection time during the call, got %v", got.CollectedAt)
+	}
+}
+
+func TestGetStatsFor_ReportsURLAndBodyOfNonJSONResponse(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, "<html><body>Please log in</body></html>")
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.GetStatsFor(context.Background(), "demo-backend")
+	if err == nil {
+		t.Fatal("want error, got nil")
+	}
+	for _, want := range []string{ts.URL + "/api/8/http/upstreams/demo-backend", "text/html", "Please log in"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("want error containing %q, got %q", want, err)
+		}
+	}
+}
+
+func TestGetStatsFor_TruncatesBodyOfMalformedJSONResponse(t *testing.T) {
+	t.Parallel()
+
+	body := `{"peers": [` + strings.Repeat(`{"server": "10.0.0.1:80", "state": "up"}, `, 20)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.GetStatsFor(context.Background(), "demo-backend")
+	if err == nil {
+		t.Fatal("want error, got nil")
+	}
+	if !strings.Contains(err.Error(), "peers") {
+		t.Errorf("want error containing the start of the body, got %q", err)
+	}
+	if n := strings.Count(err.Error(), "10.0.0.1:80"); n == 0 || n >= 20 {
+		t.Errorf("want body truncated, got %q", err)
+	}
+}
+
+func TestGetStatsForUpstreams_StopsPromptlyWhenContextIsCancelled(t *testing.T) {
+	t.Parallel()
+
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithMaxConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err = c.GetStatsForUpstreams(ctx, []string{"a", "b", "c", "d", "e"})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("want prompt return, took %v", elapsed)
+	}
+	if got := atomic.LoadInt32(&hits); got != 1 {
+		t.Errorf("want 1 request, got %d", got)
+	}
+}
+
+func TestWithFailFast_CancelsOutstandingRequestsOnFirstError(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/missing") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithFailFast())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	results := c.GetResultsForUpstreams(ctx, []string{"slow", "missing"})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("want prompt return, took %v", elapsed)
+	}
+	if !errors.Is(results[1].Err, nginxhealthz.ErrUpstreamNotFound) {
+		t.Errorf("want not found for missing, got %v", results[1].Err)
+	}
+	if !errors.Is(results[0].Err, context.Canceled) {
+		t.Errorf("want canceled for slow, got %v", results[0].Err)
+	}
+}
+
+func TestGetUpstreamsFor_SkipsEntriesWithoutZone(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"demo-backend": {"zone": "bar.example.org-demo-backend"},
+		"no-zone": {},
+		"numeric-zone": {"zone": 7},
+		"not-an-object": [1, 2]
+	}`, "/api/8/http/upstreams?fields=zone", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetUpstreamsFor(context.Background(), "bar.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"bar.example.org": {"demo-backend"}}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+// newLargeUpstreamsResponse returns an upstreams listing with the
+// given number of upstreams, each with the given number of peers.
+func newLargeUpstreamsResponse(upstreams, peers int) string {
+	var b strings.Builder
+	b.WriteString("{")
+	for u := 0; u < upstreams; u++ {
+		if u > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, `"backend-%d": {"zone": "bar.example.org-backend-%d", "peers": [`, u, u)
+		for p := 0; p < peers; p++ {
+			if p > 0 {
+				b.WriteString(",")
+			}
+			fmt.Fprintf(&b, `{"id": %d, "server": "10.0.%d.%d:8080", "name": "10.0.%d.%d:8080", "state": "up", "weight": 1, "requests": 19803806, "sent": 7525574329, "received": 16975650045, "responses": {"2xx": 19803806, "codes": {"200": 19803806}, "total": 19803806}}`, p, u%256, p%256, u%256, p%256)
+		}
+		b.WriteString("]}")
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func BenchmarkGetAllStats(b *testing.B) {
+	body := newLargeUpstreamsResponse(100, 20)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.GetAllStats(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestWithAPIPath_UsesCustomPrefix(t *testing.T) {
+	t.Parallel()
+
+	for _, prefix := range []string{"/nginx-api", "/nginx-api/"} {
+		ts := newTestServerWithPathValidator(validResponseGetUpstreamAllServersUp, "/nginx-api/8/http/upstreams/demo-backend?fields=peers", t)
+		c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithAPIPath(prefix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.GetStatsFor(context.Background(), "demo-backend"); err != nil {
+			t.Errorf("%s: %v", prefix, err)
+		}
+		ts.Close()
+	}
+}
+
+func TestWithAPIPath_DiscoversVersionUnderCustomPrefix(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator("[4,5,6,7,8,9]", "/nginx-api/", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithAPIPath("/nginx-api"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.DiscoverVersion(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 9 {
+		t.Errorf("want version 9, got %d", got)
+	}
+}
+
+func TestNewClient_FailsOnAPIPathWithoutLeadingSlash(t *testing.T) {
+	t.Parallel()
+
+	for _, prefix := range []string{"", "nginx-api", "http://example.org/api"} {
+		if _, err := nginxhealthz.NewClient("http://localhost:9001", nginxhealthz.WithAPIPath(prefix)); err == nil {
+			t.Errorf("%q: want error, got nil", prefix)
+		}
+	}
+}
+
+func TestGetWeightedStatsFor_SumsWeightsOfUpAndAllPeers(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"peers": [
+			{"server": "10.0.0.1:80", "state": "up", "weight": 5},
+			{"server": "10.0.0.2:80", "state": "down", "weight": 5},
+			{"server": "10.0.0.3:80", "state": "up", "weight": 1},
+			{"server": "10.0.0.4:80", "state": "unavail", "weight": 1}
+		]
+	}`, "/api/8/http/upstreams/demo-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetWeightedStatsFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.WeightedStats{
+		Stats:       nginxhealthz.Stats{Total: 4, Up: 2, Down: 1, Unavail: 1},
+		WeightUp:    6,
+		WeightTotal: 12,
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	if f := got.WeightUpFraction(); f != 0.5 {
+		t.Errorf("want weight up fraction 0.5, got %v", f)
+	}
+}
+
+func TestGetWeightedStatsFor_ExcludesBackupPeersWhenConfigured(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"peers": [
+			{"server": "10.0.0.1:80", "state": "up", "weight": 3},
+			{"server": "10.0.0.2:80", "state": "up", "weight": 2, "backup": true}
+		]
+	}`, "/api/8/http/upstreams/demo-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithBackupExcluded())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetWeightedStatsFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.WeightUp != 3 || got.WeightTotal != 3 {
+		t.Errorf("want weights 3/3, got %d/%d", got.WeightUp, got.WeightTotal)
+	}
+}
+
+func TestGetWeightedStatsFor_CountsDrainingWeightAsUpWhenConfigured(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"peers": [
+			{"server": "10.0.0.1:80", "state": "up", "weight": 3},
+			{"server": "10.0.0.2:80", "state": "draining", "weight": 2}
+		]
+	}`, "/api/8/http/upstreams/demo-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithDrainingCountsAsUp())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetWeightedStatsFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.WeightUp != 5 || got.WeightTotal != 5 {
+		t.Errorf("want weights 5/5, got %d/%d", got.WeightUp, got.WeightTotal)
+	}
+}
+
+func TestGetStatsFor_CountsPeersWithEmptyStateAsUnknown(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"peers": [
+			{"server": "10.0.0.1:80", "state": "up"},
+			{"server": "10.0.0.2:80", "state": ""},
+			{"server": "10.0.0.3:80", "state": "rebooting"}
+		]
+	}`, "/api/8/http/upstreams/demo-backend?fields=peers", t)
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithLogger(slog.New(slog.NewTextHandler(&buf, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetStatsFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.Stats{Total: 3, Up: 1, Unknown: 2}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	if log := buf.String(); !strings.Contains(log, "level=WARN") || !strings.Contains(log, "upstream=demo-backend") {
+		t.Errorf("want warning naming the upstream, got %q", log)
+	}
+}
+
+func TestListHosts_ReturnsSortedDistinctHostnames(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(validResponseGetUpstreamsZones, "/api/8/http/upstreams?fields=zone", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.ListHosts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"bar.example.com", "bar.example.org", "foo.example.com"}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestListHosts_SkipsZonesWithoutHostname(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"hg-backend": {"zone": "bar.example.org-hg-backend"},
+		"plain": {"zone": "plain"},
+		"no-zone": {}
+	}`, "/api/8/http/upstreams?fields=zone", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.ListHosts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"bar.example.org"}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetStatsForZone_ReturnsStatsOfUpstreamWithZone(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetStatsForZone(context.Background(), "bar.example.org-hg-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.Stats{Total: 2, Up: 1, Down: 1}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetStatsForZone_SumsUpstreamsSharingZone(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/http/upstreams"):
+			io.WriteString(w, `{
+				"hg-backend": {"zone": "shared"},
+				"demo-backend": {"zone": "shared"},
+				"lxr-backend": {"zone": "bar.example.org-lxr-backend"}
+			}`)
+		case strings.HasSuffix(r.URL.Path, "/hg-backend"):
+			io.WriteString(w, validResponseUpstreamHGbackend)
+		case strings.HasSuffix(r.URL.Path, "/demo-backend"):
+			io.WriteString(w, validResponseGetUpstreamAllServersUp)
+		default:
+			t.Errorf("unexpected request for %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetStatsForZone(context.Background(), "shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.Stats{Total: 4, Up: 3, Down: 1}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetStatsForZone_ReturnsTypedErrorForUnknownZone(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.GetStatsForZone(context.Background(), "bar.example.org-missing")
+	var zoneErr *nginxhealthz.UpstreamZoneNotFoundError
+	if !errors.As(err, &zoneErr) {
+		t.Fatalf("want UpstreamZoneNotFoundError, got %v", err)
+	}
+	if zoneErr.Zone != "bar.example.org-missing" {
+		t.Errorf("want zone bar.example.org-missing, got %q", zoneErr.Zone)
+	}
+}
@@ -0,0 +1,72 @@
+package nginxhealthz
+
+import (
+	"sync"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of the latency histogram
+// in ClientMetrics.
+var LatencyBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// ClientMetrics holds counters of the requests the client sent to the
+// NGINX API. A request fails when it gets no response or a response
+// other than 200. Every retry counts as a request.
+type ClientMetrics struct {
+	Requests  int64
+	Successes int64
+	Failures  int64
+	// LatencyCounts holds the number of requests that took at most
+	// the duration of the LatencyBuckets entry at the same index.
+	// The last entry counts the slower requests.
+	LatencyCounts []int64
+	// LatencySum is the total time spent on requests.
+	LatencySum time.Duration
+}
+
+type clientMetrics struct {
+	mu sync.Mutex
+	m  ClientMetrics
+}
+
+func (cm *clientMetrics) observe(d time.Duration, ok bool) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if cm.m.LatencyCounts == nil {
+		cm.m.LatencyCounts = make([]int64, len(LatencyBuckets)+1)
+	}
+	cm.m.Requests++
+	if ok {
+		cm.m.Successes++
+	} else {
+		cm.m.Failures++
+	}
+	i := 0
+	for i < len(LatencyBuckets) && d > LatencyBuckets[i] {
+		i++
+	}
+	cm.m.LatencyCounts[i]++
+	cm.m.LatencySum += d
+}
+
+// ClientMetrics returns a snapshot of the request counters.
+func (c *Client) ClientMetrics() ClientMetrics {
+	c.metrics.mu.Lock()
+	defer c.metrics.mu.Unlock()
+	m := c.metrics.m
+	m.LatencyCounts = make([]int64, len(LatencyBuckets)+1)
+	copy(m.LatencyCounts, c.metrics.m.LatencyCounts)
+	return m
+}
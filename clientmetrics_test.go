@@ -0,0 +1,63 @@
+package nginxhealthz_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+func TestClientMetrics_CountsRequestsAndOutcomes(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/missing-backend") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(validResponseGetUpstreamAllServersUp))
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []string{"demo-backend", "demo-backend", "missing-backend"} {
+		c.GetStatsFor(context.Background(), u)
+	}
+
+	got := c.ClientMetrics()
+	if got.Requests != 3 || got.Successes != 2 || got.Failures != 1 {
+		t.Errorf("want 3 requests, 2 successes and 1 failure, got %+v", got)
+	}
+	if len(got.LatencyCounts) != len(nginxhealthz.LatencyBuckets)+1 {
+		t.Fatalf("want %d latency buckets, got %d", len(nginxhealthz.LatencyBuckets)+1, len(got.LatencyCounts))
+	}
+	var observed int64
+	for _, n := range got.LatencyCounts {
+		observed += n
+	}
+	if observed != 3 {
+		t.Errorf("want 3 latency observations, got %d", observed)
+	}
+	if got.LatencySum <= 0 {
+		t.Errorf("want positive latency sum, got %v", got.LatencySum)
+	}
+}
+
+func TestClientMetrics_ReturnsEmptySnapshotBeforeRequests(t *testing.T) {
+	t.Parallel()
+
+	c, err := nginxhealthz.NewClient("http://localhost:9001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := c.ClientMetrics()
+	if got.Requests != 0 || len(got.LatencyCounts) != len(nginxhealthz.LatencyBuckets)+1 {
+		t.Errorf("unexpected snapshot %+v", got)
+	}
+}
@@ -0,0 +1,29 @@
+package nginxhealthz
+
+import (
+	"errors"
+	"time"
+)
+
+// Clock tells the current time. It is used by the time-based features
+// of the client, such as WithCache, GetStatsSnapshotFor, the snapshots
+// of WithSnapshotFile and NewHostCache, so tests can control time.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// WithClock makes the client read the time from clk
+// instead of the system clock.
+func WithClock(clk Clock) option {
+	return func(c *Client) error {
+		if clk == nil {
+			return errors.New("nil clock")
+		}
+		c.clock = clk
+		return nil
+	}
+}
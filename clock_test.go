@@ -0,0 +1,149 @@
+package nginxhealthz_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+// fakeClock is a Clock that only moves when advanced.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newCountingServer returns a fake NGINX API serving an upstream
+// with all peers up and counting the requests it gets.
+func newCountingServer(t *testing.T, hits *int32) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		io.WriteString(w, validResponseGetUpstreamAllServersUp)
+	}))
+}
+
+func TestWithClock_ExpiresCachedStatsAfterTTL(t *testing.T) {
+	t.Parallel()
+
+	var hits int32
+	ts := newCountingServer(t, &hits)
+	defer ts.Close()
+
+	clock := newFakeClock()
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithCache(time.Minute), nginxhealthz.WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := c.GetStatsFor(ctx, "demo-backend"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(59 * time.Second)
+	if _, err := c.GetStatsFor(ctx, "demo-backend"); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(&hits); got != 1 {
+		t.Errorf("want 1 request within the TTL, got %d", got)
+	}
+	clock.Advance(time.Second)
+	if _, err := c.GetStatsFor(ctx, "demo-backend"); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(&hits); got != 2 {
+		t.Errorf("want 2 requests once the TTL passed, got %d", got)
+	}
+}
+
+func TestWithClock_SetsSnapshotCollectionTime(t *testing.T) {
+	t.Parallel()
+
+	var hits int32
+	ts := newCountingServer(t, &hits)
+	defer ts.Close()
+
+	clock := newFakeClock()
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithCache(time.Minute), nginxhealthz.WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := clock.Now()
+	s, err := c.GetStatsSnapshotFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(30 * time.Second)
+	cached, err := c.GetStatsSnapshotFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.CollectedAt.Equal(want) || !cached.CollectedAt.Equal(want) {
+		t.Errorf("want collection time %v, got %v and %v", want, s.CollectedAt, cached.CollectedAt)
+	}
+}
+
+func TestWithClock_ExpiresHostCacheEntriesAfterTTL(t *testing.T) {
+	t.Parallel()
+
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		io.WriteString(w, validResponseGetUpstreamsZones)
+	}))
+	defer ts.Close()
+
+	clock := newFakeClock()
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc := nginxhealthz.NewHostCache(c, time.Minute, 10*time.Second)
+	defer hc.Close()
+
+	ctx := context.Background()
+	// The host does not exist, so the error is cached for the negative TTL.
+	if _, err := hc.GetStatsForHost(ctx, "missing.example.org"); err == nil {
+		t.Fatal("want error for unknown host")
+	}
+	before := atomic.LoadInt32(&hits)
+	clock.Advance(9 * time.Second)
+	hc.GetStatsForHost(ctx, "missing.example.org")
+	if got := atomic.LoadInt32(&hits); got != before {
+		t.Errorf("want cached error within the negative TTL, got %d new requests", got-before)
+	}
+	clock.Advance(time.Second)
+	hc.GetStatsForHost(ctx, "missing.example.org")
+	if got := atomic.LoadInt32(&hits); got == before {
+		t.Error("want a new request once the negative TTL passed")
+	}
+}
+
+func TestWithClock_FailsOnNilClock(t *testing.T) {
+	t.Parallel()
+
+	if _, err := nginxhealthz.NewClient("http://localhost:9001", nginxhealthz.WithClock(nil)); err == nil {
+		t.Error("want error, got nil")
+	}
+}
@@ -1,13 +1,130 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	nginxhealthz "github.com/qba73/nginx-healthz"
 )
 
 func main() {
-	if err := nginxhealthz.RunServer(); err != nil {
+	opts, err := parseArgs(os.Args[1:])
+	if err != nil {
 		log.Fatal(err)
 	}
+	cfg := opts.cfg
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if len(opts.args) > 0 && opts.args[0] == "stats" {
+		if err := runStats(ctx, cfg, opts.args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if opts.check {
+		if err := nginxhealthz.CheckConfig(ctx, cfg, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := nginxhealthz.RunServerWithConfig(ctx, cfg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// options holds the parsed command line.
+type options struct {
+	cfg   nginxhealthz.ServerConfig
+	check bool
+	// args are the arguments left after the flags.
+	args []string
+}
+
+// parseArgs parses the command line flags and loads the configuration
+// with nginxhealthz.LoadServerConfig, applying the flags given
+// explicitly on top of the file and the environment.
+func parseArgs(args []string) (options, error) {
+	fs := flag.NewFlagSet("nginx-healtz-api", flag.ExitOnError)
+	var flags nginxhealthz.ServerConfig
+	fs.StringVar(&flags.ListenAddr, "addr", ":8080", "address the health server listens on (env NGINX_HEALTHZ_LISTEN_ADDR)")
+	fs.StringVar(&flags.NGINXBaseURL, "nginx-url", "", "base URL of the NGINX Plus API (env NGINX_HEALTHZ_NGINX_URL)")
+	fs.IntVar(&flags.MaxConcurrentHosts, "max-concurrent-hosts", 0, "maximum number of hosts scraped concurrently, 0 means no limit (env NGINX_HEALTHZ_MAX_CONCURRENT_HOSTS)")
+	fs.IntVar(&flags.APIVersion, "api-version", 0, "NGINX Plus API version, 0 means the client default (env NGINX_API_VERSION)")
+	fs.DurationVar(&flags.ReadTimeout, "read-timeout", 0, "health server read timeout (0 means no timeout)")
+	fs.StringVar(&flags.SnapshotFile, "snapshot-file", "", "file persisting the last known stats of each host across restarts")
+	fs.StringVar(&flags.MetricsHost, "metrics-host", "", "host whose upstreams are exported on /metrics")
+	readyHosts := fs.String("ready-hosts", "", "comma separated hosts checked by /readyz")
+	upstreams := fs.String("upstreams", "", "comma separated upstreams whose combined stats /healthz reports when no host is given")
+	configPath := fs.String("config", "", "JSON configuration file (env NGINX_HEALTHZ_CONFIG); environment variables override its values, and flags given explicitly override both")
+	check := fs.Bool("check", false, "check the connection to the NGINX API, print the API version and upstream count, and exit")
+	if err := fs.Parse(args); err != nil {
+		return options{}, err
+	}
+	if *readyHosts != "" {
+		flags.ReadyHosts = strings.Split(*readyHosts, ",")
+	}
+	if *upstreams != "" {
+		flags.Upstreams = strings.Split(*upstreams, ",")
+	}
+
+	cfg, err := nginxhealthz.LoadServerConfig(*configPath)
+	if err != nil {
+		return options{}, err
+	}
+	return options{
+		cfg:   overrideConfig(fs, cfg, flags),
+		check: *check,
+		args:  fs.Args(),
+	}, nil
+}
+
+// runStats runs the stats subcommand:
+//
+//	nginx-healtz-api [flags] stats [-peers] <upstream>
+func runStats(ctx context.Context, cfg nginxhealthz.ServerConfig, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	peers := fs.Bool("peers", false, "also print the peers that are not up")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: nginx-healtz-api [flags] stats [-peers] <upstream>")
+	}
+	return nginxhealthz.PrintStats(ctx, cfg, fs.Arg(0), os.Stdout, *peers)
+}
+
+// overrideConfig returns base with the fields of flags that were set
+// explicitly on the command line parsed by fs.
+func overrideConfig(fs *flag.FlagSet, base, flags nginxhealthz.ServerConfig) nginxhealthz.ServerConfig {
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			base.ListenAddr = flags.ListenAddr
+		case "nginx-url":
+			base.NGINXBaseURL = flags.NGINXBaseURL
+		case "max-concurrent-hosts":
+			base.MaxConcurrentHosts = flags.MaxConcurrentHosts
+		case "api-version":
+			base.APIVersion = flags.APIVersion
+		case "read-timeout":
+			base.ReadTimeout = flags.ReadTimeout
+		case "snapshot-file":
+			base.SnapshotFile = flags.SnapshotFile
+		case "metrics-host":
+			base.MetricsHost = flags.MetricsHost
+		case "ready-hosts":
+			base.ReadyHosts = flags.ReadyHosts
+		case "upstreams":
+			base.Upstreams = flags.Upstreams
+		}
+	})
+	return base
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfig(t *testing.T, config string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// setenv sets the configuration variables for the test,
+// clearing the ones not given.
+func setenv(t *testing.T, vars map[string]string) {
+	t.Helper()
+	for _, k := range []string{
+		"NGINX_HEALTHZ_CONFIG",
+		"NGINX_HEALTHZ_LISTEN_ADDR",
+		"NGINX_HEALTHZ_NGINX_URL",
+		"NGINX_HEALTHZ_MAX_CONCURRENT_HOSTS",
+		"NGINX_API_VERSION",
+	} {
+		t.Setenv(k, vars[k])
+	}
+}
+
+func TestParseArgs_AppliesFileThenEnvThenFlags(t *testing.T) {
+	path := writeConfig(t, `{
+		"listen_addr": ":9000",
+		"nginx_url": "http://file:9001",
+		"api_version": 6,
+		"max_concurrent_hosts": 2
+	}`)
+	setenv(t, map[string]string{
+		"NGINX_HEALTHZ_NGINX_URL":            "http://env:9001",
+		"NGINX_HEALTHZ_MAX_CONCURRENT_HOSTS": "4",
+		"NGINX_API_VERSION":                  "7",
+	})
+
+	opts, err := parseArgs([]string{"-config", path, "-api-version", "8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := opts.cfg
+	if cfg.ListenAddr != ":9000" {
+		t.Errorf("want listen address from the file, got %q", cfg.ListenAddr)
+	}
+	if cfg.NGINXBaseURL != "http://env:9001" {
+		t.Errorf("want NGINX URL from the environment, got %q", cfg.NGINXBaseURL)
+	}
+	if cfg.MaxConcurrentHosts != 4 {
+		t.Errorf("want max concurrent hosts from the environment, got %d", cfg.MaxConcurrentHosts)
+	}
+	if cfg.APIVersion != 8 {
+		t.Errorf("want API version from the flag, got %d", cfg.APIVersion)
+	}
+}
+
+func TestParseArgs_ReadsConfigFileNamedInEnv(t *testing.T) {
+	path := writeConfig(t, `{"listen_addr": ":9000"}`)
+	setenv(t, map[string]string{
+		"NGINX_HEALTHZ_CONFIG":    path,
+		"NGINX_HEALTHZ_NGINX_URL": "http://env:9001",
+	})
+
+	opts, err := parseArgs([]string{"stats", "hg-backend"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.cfg.ListenAddr != ":9000" || opts.cfg.NGINXBaseURL != "http://env:9001" {
+		t.Errorf("want file and environment combined, got %+v", opts.cfg)
+	}
+	if len(opts.args) != 2 || opts.args[0] != "stats" {
+		t.Errorf("want stats subcommand arguments, got %q", opts.args)
+	}
+}
+
+func TestParseArgs_ReadsEnvWithoutConfigFile(t *testing.T) {
+	setenv(t, map[string]string{
+		"NGINX_HEALTHZ_LISTEN_ADDR": ":9100",
+		"NGINX_HEALTHZ_NGINX_URL":   "http://env:9001",
+	})
+
+	opts, err := parseArgs([]string{"-check"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.cfg.ListenAddr != ":9100" || opts.cfg.NGINXBaseURL != "http://env:9001" || !opts.check {
+		t.Errorf("want configuration from the environment, got %+v", opts)
+	}
+}
+
+func TestParseArgs_FailsOnInvalidEnv(t *testing.T) {
+	setenv(t, map[string]string{"NGINX_HEALTHZ_MAX_CONCURRENT_HOSTS": "many"})
+
+	if _, err := parseArgs(nil); err == nil {
+		t.Error("want error, got nil")
+	}
+}
@@ -0,0 +1,164 @@
+package nginxhealthz
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// configFile is the JSON representation of a ServerConfig.
+type configFile struct {
+	ListenAddr         string       `json:"listen_addr"`
+	NGINXURL           string       `json:"nginx_url"`
+	APIVersion         int          `json:"api_version"`
+	Username           string       `json:"username"`
+	Password           string       `json:"password"`
+	BearerToken        string       `json:"bearer_token"`
+	ReadTimeout        duration     `json:"read_timeout"`
+	WriteTimeout       duration     `json:"write_timeout"`
+	MaxConcurrentHosts int          `json:"max_concurrent_hosts"`
+	EventsInterval     duration     `json:"events_interval"`
+	CacheTTL           duration     `json:"cache_ttl"`
+	NegativeCacheTTL   duration     `json:"negative_cache_ttl"`
+	MetricsUpstreams   []string     `json:"metrics_upstreams"`
+	MetricsHost        string       `json:"metrics_host"`
+	ReadyHosts         []string     `json:"ready_hosts"`
+	Upstreams          []string     `json:"upstreams"`
+	DrainingCountsAsUp bool         `json:"draining_counts_as_up"`
+	SnapshotFile       string       `json:"snapshot_file"`
+	SnapshotMaxAge     duration     `json:"snapshot_max_age"`
+	HealthPolicy       policyConfig `json:"health_policy"`
+}
+
+type policyConfig struct {
+	MinUp         int     `json:"min_up"`
+	MinUpFraction float64 `json:"min_up_fraction"`
+}
+
+// duration is a time.Duration given as a string such as "5s".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"5s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// LoadConfig reads the health server configuration from the JSON file at
+// path. Durations are given as strings such as "5s", and unknown fields
+// are rejected to catch typos. All validation errors are joined in the
+// returned error. An example file:
+//
+//	{
+//		"listen_addr": ":8080",
+//		"nginx_url": "http://localhost:9001",
+//		"api_version": 8,
+//		"username": "healthz",
+//		"password": "secret",
+//		"read_timeout": "5s",
+//		"ready_hosts": ["bar.example.org"],
+//		"upstreams": ["hg-backend", "lxr-backend"],
+//		"health_policy": {"min_up_fraction": 0.5}
+//	}
+func LoadConfig(path string) (ServerConfig, error) {
+	return readConfig(path, true)
+}
+
+// readConfig reads the configuration file at path. Without requireURL,
+// nginx_url may be left out for LoadServerConfig to take it from the
+// environment or a flag instead.
+func readConfig(path string, requireURL bool) (ServerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ServerConfig{}, fmt.Errorf("reading config: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var f configFile
+	if err := dec.Decode(&f); err != nil {
+		return ServerConfig{}, fmt.Errorf("decoding config %s: %w", path, err)
+	}
+	if err := f.validate(requireURL); err != nil {
+		return ServerConfig{}, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return ServerConfig{
+		ListenAddr:         f.ListenAddr,
+		NGINXBaseURL:       f.NGINXURL,
+		APIVersion:         f.APIVersion,
+		Username:           f.Username,
+		Password:           f.Password,
+		BearerToken:        f.BearerToken,
+		ReadTimeout:        time.Duration(f.ReadTimeout),
+		WriteTimeout:       time.Duration(f.WriteTimeout),
+		MaxConcurrentHosts: f.MaxConcurrentHosts,
+		EventsInterval:     time.Duration(f.EventsInterval),
+		CacheTTL:           time.Duration(f.CacheTTL),
+		NegativeCacheTTL:   time.Duration(f.NegativeCacheTTL),
+		MetricsUpstreams:   f.MetricsUpstreams,
+		MetricsHost:        f.MetricsHost,
+		ReadyHosts:         f.ReadyHosts,
+		Upstreams:          f.Upstreams,
+		DrainingCountsAsUp: f.DrainingCountsAsUp,
+		SnapshotFile:       f.SnapshotFile,
+		SnapshotMaxAge:     time.Duration(f.SnapshotMaxAge),
+		HealthPolicy: HealthPolicy{
+			MinUp:         f.HealthPolicy.MinUp,
+			MinUpFraction: f.HealthPolicy.MinUpFraction,
+		},
+	}, nil
+}
+
+func (f configFile) validate(requireURL bool) error {
+	var errs []error
+	if f.NGINXURL == "" {
+		if requireURL {
+			errs = append(errs, errors.New("nginx_url is required"))
+		}
+	} else if _, err := normalizeBaseURL(f.NGINXURL); err != nil {
+		errs = append(errs, fmt.Errorf("nginx_url: %w", err))
+	}
+	if f.APIVersion != 0 && f.APIVersion < minVersion {
+		errs = append(errs, fmt.Errorf("api_version: unsupported NGINX version: %d", f.APIVersion))
+	}
+	if f.Password != "" && f.Username == "" {
+		errs = append(errs, errors.New("password is set without username"))
+	}
+	if f.Username != "" && f.BearerToken != "" {
+		errs = append(errs, errors.New("username and bearer_token are mutually exclusive"))
+	}
+	for _, d := range []struct {
+		name  string
+		value duration
+	}{
+		{"read_timeout", f.ReadTimeout},
+		{"write_timeout", f.WriteTimeout},
+		{"events_interval", f.EventsInterval},
+		{"cache_ttl", f.CacheTTL},
+		{"negative_cache_ttl", f.NegativeCacheTTL},
+		{"snapshot_max_age", f.SnapshotMaxAge},
+	} {
+		if d.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", d.name))
+		}
+	}
+	if f.MaxConcurrentHosts < 0 {
+		errs = append(errs, errors.New("max_concurrent_hosts must not be negative"))
+	}
+	if f.HealthPolicy.MinUp < 0 {
+		errs = append(errs, errors.New("health_policy.min_up must not be negative"))
+	}
+	if f.HealthPolicy.MinUpFraction < 0 || f.HealthPolicy.MinUpFraction > 1 {
+		errs = append(errs, errors.New("health_policy.min_up_fraction must be between 0 and 1"))
+	}
+	return errors.Join(errs...)
+}
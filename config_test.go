@@ -0,0 +1,191 @@
+package nginxhealthz_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+// writeConfig writes the config to a file in a temporary directory
+// and returns its path.
+func writeConfig(t *testing.T, config string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig_ReadsAllFields(t *testing.T) {
+	t.Parallel()
+	path := writeConfig(t, `{
+		"listen_addr": ":9090",
+		"nginx_url": "http://localhost:9001",
+		"api_version": 8,
+		"username": "healthz",
+		"password": "secret",
+		"read_timeout": "5s",
+		"write_timeout": "1m",
+		"max_concurrent_hosts": 4,
+		"events_interval": "2s",
+		"cache_ttl": "10s",
+		"negative_cache_ttl": "1s",
+		"metrics_upstreams": ["hg-backend"],
+		"metrics_host": "bar.example.org",
+		"ready_hosts": ["bar.example.org", "foo.example.org"],
+		"upstreams": ["hg-backend", "lxr-backend"],
+		"draining_counts_as_up": true,
+		"snapshot_file": "/var/lib/nginx-healthz/snapshot.json",
+		"snapshot_max_age": "2m",
+		"health_policy": {"min_up": 1, "min_up_fraction": 0.5}
+	}`)
+
+	got, err := nginxhealthz.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.ServerConfig{
+		ListenAddr:         ":9090",
+		NGINXBaseURL:       "http://localhost:9001",
+		APIVersion:         8,
+		Username:           "healthz",
+		Password:           "secret",
+		ReadTimeout:        5 * time.Second,
+		WriteTimeout:       time.Minute,
+		MaxConcurrentHosts: 4,
+		EventsInterval:     2 * time.Second,
+		CacheTTL:           10 * time.Second,
+		NegativeCacheTTL:   time.Second,
+		MetricsUpstreams:   []string{"hg-backend"},
+		MetricsHost:        "bar.example.org",
+		ReadyHosts:         []string{"bar.example.org", "foo.example.org"},
+		Upstreams:          []string{"hg-backend", "lxr-backend"},
+		DrainingCountsAsUp: true,
+		SnapshotFile:       "/var/lib/nginx-healthz/snapshot.json",
+		SnapshotMaxAge:     2 * time.Minute,
+		HealthPolicy:       nginxhealthz.HealthPolicy{MinUp: 1, MinUpFraction: 0.5},
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestLoadConfig_ReportsAllValidationErrors(t *testing.T) {
+	t.Parallel()
+	path := writeConfig(t, `{
+		"api_version": 2,
+		"read_timeout": "-1s",
+		"username": "healthz",
+		"bearer_token": "token",
+		"health_policy": {"min_up_fraction": 2}
+	}`)
+
+	_, err := nginxhealthz.LoadConfig(path)
+	if err == nil {
+		t.Fatal("want error, got nil")
+	}
+	for _, want := range []string{
+		"nginx_url is required",
+		"api_version",
+		"read_timeout must not be negative",
+		"mutually exclusive",
+		"min_up_fraction",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestLoadConfig_ReportsNegativeDurationsInFieldOrder(t *testing.T) {
+	t.Parallel()
+	path := writeConfig(t, `{
+		"nginx_url": "http://localhost:9001",
+		"read_timeout": "-1s",
+		"write_timeout": "-1s",
+		"events_interval": "-1s",
+		"cache_ttl": "-1s",
+		"negative_cache_ttl": "-1s",
+		"snapshot_max_age": "-1s"
+	}`)
+
+	want := strings.Join([]string{
+		"read_timeout must not be negative",
+		"write_timeout must not be negative",
+		"events_interval must not be negative",
+		"cache_ttl must not be negative",
+		"negative_cache_ttl must not be negative",
+		"snapshot_max_age must not be negative",
+	}, "\n")
+	for i := 0; i < 10; i++ {
+		_, err := nginxhealthz.LoadConfig(path)
+		if err == nil {
+			t.Fatal("want error, got nil")
+		}
+		if !strings.HasSuffix(err.Error(), ": "+want) {
+			t.Fatalf("want errors\n%s\ngot\n%s", want, err)
+		}
+	}
+}
+
+func TestLoadConfig_ErrorsOn(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{name: "unknown field", config: `{"nginx_url": "http://localhost", "listen": ":80"}`},
+		{name: "numeric duration", config: `{"nginx_url": "http://localhost", "read_timeout": 5}`},
+		{name: "invalid duration", config: `{"nginx_url": "http://localhost", "read_timeout": "5 seconds"}`},
+		{name: "invalid URL", config: `{"nginx_url": "localhost:9001"}`},
+		{name: "malformed JSON", config: `{"nginx_url": `},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := nginxhealthz.LoadConfig(writeConfig(t, tt.config))
+			if err == nil {
+				t.Error("want error, got nil")
+			}
+		})
+	}
+}
+
+func TestLoadConfig_ErrorsOnMissingFile(t *testing.T) {
+	t.Parallel()
+	_, err := nginxhealthz.LoadConfig(filepath.Join(t.TempDir(), "missing.json"))
+	if !os.IsNotExist(errors.Unwrap(err)) {
+		t.Errorf("want not exist error, got %v", err)
+	}
+}
+
+func TestLoadServerConfig_OverridesFileWithEnv(t *testing.T) {
+	path := writeConfig(t, `{"listen_addr": ":9000", "api_version": 6}`)
+	t.Setenv("NGINX_HEALTHZ_CONFIG", "")
+	t.Setenv("NGINX_HEALTHZ_LISTEN_ADDR", "")
+	t.Setenv("NGINX_HEALTHZ_MAX_CONCURRENT_HOSTS", "")
+	t.Setenv("NGINX_HEALTHZ_NGINX_URL", "http://localhost:9001")
+	t.Setenv("NGINX_API_VERSION", "7")
+
+	got, err := nginxhealthz.LoadServerConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.ServerConfig{
+		ListenAddr:   ":9000",
+		NGINXBaseURL: "http://localhost:9001",
+		APIVersion:   7,
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
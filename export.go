@@ -0,0 +1,64 @@
+package nginxhealthz
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// ExportCSV writes a header row followed by one row per upstream with
+// the host, upstream, total, up and down columns. Rows are ordered by host,
+// then by upstream name. Upstreams whose zone name does not include a
+// hostname are listed under UnknownHost, as in GetHealthMatrix.
+func (c *Client) ExportCSV(ctx context.Context, w io.Writer) error {
+	upstreams, err := c.getUpstreams(ctx, "")
+	if err != nil {
+		return fmt.Errorf("retrieving upstreams: %w", err)
+	}
+	stats := c.statsFromUpstreams(upstreams)
+
+	type row struct {
+		host, upstream string
+		stats          Stats
+	}
+	rows := make([]row, 0, len(stats))
+	for name, s := range stats {
+		host, ok := c.hostFromZone(upstreams[name].Zone, name)
+		if !ok {
+			host = UnknownHost
+		}
+		rows = append(rows, row{
+			host:     host,
+			upstream: name,
+			stats:    s,
+		})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].host != rows[j].host {
+			return rows[i].host < rows[j].host
+		}
+		return rows[i].upstream < rows[j].upstream
+	})
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"host", "upstream", "total", "up", "down"}); err != nil {
+		return fmt.Errorf("writing header: %w", err)
+	}
+	for _, r := range rows {
+		record := []string{
+			r.host,
+			r.upstream,
+			strconv.Itoa(r.stats.Total),
+			strconv.Itoa(r.stats.Up),
+			strconv.Itoa(r.stats.Down),
+		}
+		if err := cw.Write(record); err != nil {
+			return fmt.Errorf("writing row: %w", err)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,65 @@
+package nginxhealthz_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+func TestExportCSV_WritesRowsOrderedByHostAndUpstream(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(
+		validResponseGetAllUpstreams,
+		"/api/8/http/upstreams", t,
+	)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := c.ExportCSV(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "host,upstream,total,up,down\n" +
+		"bar.example.org,hg-backend,2,1,1\n" +
+		"bar.example.org,lxr-backend,2,2,0\n"
+	if !cmp.Equal(want, buf.String()) {
+		t.Error(cmp.Diff(want, buf.String()))
+	}
+}
+
+func TestExportCSV_ListsUpstreamsWithoutHostUnderUnknownHost(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"hg-backend": {"zone": "bar.example.org-hg-backend", "peers": [{"state": "up"}]},
+		"legacy": {"zone": "legacy", "peers": [{"state": "down"}]}
+	}`, "/api/8/http/upstreams", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := c.ExportCSV(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "host,upstream,total,up,down\n" +
+		"bar.example.org,hg-backend,1,1,0\n" +
+		nginxhealthz.UnknownHost + ",legacy,1,0,1\n"
+	if !cmp.Equal(want, buf.String()) {
+		t.Error(cmp.Diff(want, buf.String()))
+	}
+}
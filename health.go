@@ -0,0 +1,113 @@
+package nginxhealthz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// IsHealthy reports whether all peers of the upstream are up. It stops
+// at the first peer that is not up.
+func (c *Client) IsHealthy(ctx context.Context, upstream string) (bool, error) {
+	res, err := c.getUpstream(ctx, upstream)
+	if err != nil {
+		return false, err
+	}
+	if len(res.Peers) < 1 {
+		return false, ErrNoPeers
+	}
+	for _, p := range res.Peers {
+		if !c.isUp(p.State) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// IsHostHealthy reports whether all peers of all upstreams of the host
+// are up. The upstreams are checked concurrently, and the remaining
+// checks are cancelled as soon as one upstream is known to be unhealthy
+// or fails to be checked.
+func (c *Client) IsHostHealthy(ctx context.Context, hostname string) (bool, error) {
+	upstreams, err := c.hostUpstreams(ctx, hostname)
+	if err != nil {
+		return false, fmt.Errorf("checking host %s: %w", hostname, err)
+	}
+	ux, ok := upstreams[hostname]
+	if !ok {
+		return false, fmt.Errorf("no stat data for host %s", hostname)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		upstream string
+		healthy  bool
+		err      error
+	}
+	results := make(chan result, len(ux))
+	for _, u := range ux {
+		go func(upstream string) {
+			healthy, err := c.IsHealthy(ctx, upstream)
+			results <- result{upstream: upstream, healthy: healthy, err: err}
+		}(u)
+	}
+	for range ux {
+		r := <-results
+		if r.err != nil {
+			return false, fmt.Errorf("checking upstream %s: %w", r.upstream, r.err)
+		}
+		if !r.healthy {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// GetDownPeersFor returns the peers of the upstream that are not up,
+// identified as set by WithPeerIdentity. By default these are their
+// server addresses as reported by NGINX, for example "10.0.0.2:80"
+// or "[2001:db8::1]:8080".
+func (c *Client) GetDownPeersFor(ctx context.Context, upstream string) ([]string, error) {
+	res, err := c.getUpstream(ctx, upstream)
+	if err != nil {
+		return nil, err
+	}
+	var down []string
+	for _, p := range res.Peers {
+		if !c.isUp(p.State) {
+			down = append(down, c.peerID(p.Server, p.Name))
+		}
+	}
+	return down, nil
+}
+
+// GetDownPeersForHost returns the peers that are not up, identified as
+// by GetDownPeersFor, for each upstream of the host that has any.
+// Upstreams failing to be queried are left out and their errors joined
+// in the returned error.
+func (c *Client) GetDownPeersForHost(ctx context.Context, hostname string) (map[string][]string, error) {
+	upstreams, err := c.hostUpstreams(ctx, hostname)
+	if err != nil {
+		return nil, fmt.Errorf("getting down peers for host %s: %w", hostname, err)
+	}
+	ux, ok := upstreams[hostname]
+	if !ok {
+		return nil, fmt.Errorf("no stat data for host %s", hostname)
+	}
+
+	servers, errs := fanOut(ctx, c, ux, false, c.GetDownPeersFor)
+	down := make(map[string][]string)
+	var failed []error
+	for i, u := range ux {
+		if errs[i] != nil {
+			failed = append(failed, fmt.Errorf("upstream %s: %w", u, errs[i]))
+			continue
+		}
+		if len(servers[i]) > 0 {
+			down[u] = servers[i]
+		}
+	}
+	return down, errors.Join(failed...)
+}
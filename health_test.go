@@ -0,0 +1,338 @@
+package nginxhealthz_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+func TestIsHealthy_ReportsFalseWhenPeerIsDown(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthy, err := c.IsHealthy(context.Background(), "hg-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if healthy {
+		t.Error("want unhealthy upstream")
+	}
+}
+
+func TestIsHealthy_ReportsTrueWhenAllPeersAreUp(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthy, err := c.IsHealthy(context.Background(), "lxr-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !healthy {
+		t.Error("want healthy upstream")
+	}
+}
+
+func TestIsHealthy_FailsWithErrNoPeersOnUpstreamWithoutPeers(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{"peers": []}`, "/api/8/http/upstreams/demo-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.IsHealthy(context.Background(), "demo-backend")
+	if !errors.Is(err, nginxhealthz.ErrNoPeers) {
+		t.Errorf("want ErrNoPeers, got %v", err)
+	}
+}
+
+const responseUpstreamUpAndDraining = `{
+	"peers": [
+		{"server": "10.0.0.1:80", "state": "up"},
+		{"server": "10.0.0.2:80", "state": "draining"}
+	]
+}`
+
+func TestIsHealthy_ReportsFalseWhenPeerIsDraining(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(responseUpstreamUpAndDraining, "/api/8/http/upstreams/demo-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthy, err := c.IsHealthy(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if healthy {
+		t.Error("want unhealthy upstream")
+	}
+}
+
+func TestIsHealthy_ReportsTrueWhenDrainingCountsAsUp(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(responseUpstreamUpAndDraining, "/api/8/http/upstreams/demo-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithDrainingCountsAsUp())
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthy, err := c.IsHealthy(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !healthy {
+		t.Error("want healthy upstream")
+	}
+	down, err := c.GetDownPeersFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(down) != 0 {
+		t.Errorf("want no down peers, got %v", down)
+	}
+}
+
+func TestIsHostHealthy_CancelsRemainingChecksOnUnhealthyUpstream(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/http/upstreams"):
+			io.WriteString(w, validResponseGetUpstreamsZones)
+		case strings.HasSuffix(r.URL.Path, "/lxr-backend"):
+			// A slow upstream, which must not be waited for
+			// once hg-backend is known to be unhealthy.
+			close(started)
+			select {
+			case <-r.Context().Done():
+				close(cancelled)
+			case <-time.After(5 * time.Second):
+				io.WriteString(w, validResponseUpstreamLXRbackend)
+			}
+		default:
+			// Respond only once the lxr-backend check is in
+			// flight, so there is a request left to cancel.
+			select {
+			case <-started:
+			case <-time.After(5 * time.Second):
+			}
+			io.WriteString(w, validResponseUpstreamHGbackend)
+		}
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthy, err := c.IsHostHealthy(context.Background(), "bar.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if healthy {
+		t.Error("want unhealthy host")
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("want remaining check cancelled")
+	}
+}
+
+func TestIsHostHealthy_FailsOnUnknownHost(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.IsHostHealthy(context.Background(), "unknown.example.org"); err == nil {
+		t.Fatal("want error")
+	}
+}
+
+func TestGetDownPeersFor_ReturnsServersNotUp(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"peers": [
+			{"server": "10.0.0.1:80", "state": "up"},
+			{"server": "10.0.0.2:80", "state": "down"},
+			{"server": "10.0.0.3:80", "state": "unavail"}
+		]
+	}`, "/api/8/http/upstreams/demo-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetDownPeersFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.2:80", "10.0.0.3:80"}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetDownPeersFor_UsesConfiguredPeerIdentity(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"peers": [
+			{"server": "10.0.0.1:80", "name": "app1.example.org:80", "state": "up"},
+			{"server": "10.0.0.2:80", "name": "app2.example.org:80", "state": "down"}
+		]
+	}`, "/api/8/http/upstreams/demo-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithPeerIdentity(nginxhealthz.PeerIdentityName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetDownPeersFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"app2.example.org:80"}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetDownPeersFor_KeepsIPv6AddressesIntact(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"peers": [
+			{"server": "10.0.0.1:8080", "state": "down"},
+			{"server": "[2001:db8::1]:8080", "state": "down"},
+			{"server": "[2001:db8::2]:8080", "state": "up"}
+		]
+	}`, "/api/8/http/upstreams/demo-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetDownPeersFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.1:8080", "[2001:db8::1]:8080"}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetDownPeersForHost_ReturnsDownServersPerUpstream(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetDownPeersForHost(context.Background(), "bar.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"hg-backend": {"10.0.0.41:8084"}}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+// newTestStreamNGINX returns a fake NGINX API serving only stream
+// upstreams: the mysql-backend upstream of the bar.example.org host.
+func newTestStreamNGINX(t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/8/stream/upstreams":
+			io.WriteString(w, `{"mysql-backend": {"zone": "bar.example.org-mysql-backend"}}`)
+		case "/api/8/stream/upstreams/mysql-backend":
+			io.WriteString(w, validResponseUpstreamHGbackend)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestIsHostHealthy_ChecksStreamUpstreamsWithStreamProtocol(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestStreamNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithProtocol(nginxhealthz.ProtocolStream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthy, err := c.IsHostHealthy(context.Background(), "bar.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if healthy {
+		t.Error("want unhealthy host")
+	}
+}
+
+func TestGetDownPeersForHost_ReadsStreamUpstreamsWithStreamProtocol(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestStreamNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithProtocol(nginxhealthz.ProtocolStream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetDownPeersForHost(context.Background(), "bar.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"mysql-backend": {"10.0.0.41:8084"}}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
@@ -1,17 +1,658 @@
 package nginxhealthz
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// ServerConfig holds the configuration of the health server.
+type ServerConfig struct {
+	ListenAddr   string
+	NGINXBaseURL string
+	// APIVersion is the NGINX Plus API version used by the client.
+	// Zero means the client default.
+	APIVersion int
+	// Username and Password set basic auth credentials, and BearerToken
+	// a bearer token, for the NGINX API.
+	Username    string
+	Password    string
+	BearerToken string
+	// ReadTimeout and WriteTimeout configure the HTTP server. Zero
+	// means no timeout. A WriteTimeout also ends /events streams.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// MaxConcurrentHosts limits the number of hosts scraped at the same
+	// time across all requests served by the server. Requests wait for a
+	// free slot until their context is done. Zero means no limit.
+	MaxConcurrentHosts int
+	// EventsInterval is how often the /events endpoint polls
+	// the NGINX API. It defaults to 5 seconds.
+	EventsInterval time.Duration
+	// CacheTTL and NegativeCacheTTL set how long successful and failed
+	// host scrapes are cached. Zero disables caching.
+	CacheTTL         time.Duration
+	NegativeCacheTTL time.Duration
+	// MetricsUpstreams and MetricsHost select the upstreams exported
+	// on /metrics, either listed or discovered for the host. The
+	// endpoint is not served when both are empty.
+	MetricsUpstreams []string
+	MetricsHost      string
+	// HealthPolicy decides whether a host checked on /healthz is
+	// healthy. The zero value requires all peers to be up.
+	HealthPolicy HealthPolicy
+	// ReadyHosts are the hosts checked by /readyz when the request
+	// names none.
+	ReadyHosts []string
+	// DrainingCountsAsUp counts draining peers as up,
+	// as WithDrainingCountsAsUp does.
+	DrainingCountsAsUp bool
+	// SnapshotFile persists the last known stats of each host, as
+	// WithSnapshotFile does. After a restart, a host that fails to be
+	// scraped is reported from the file while its stats are at most
+	// SnapshotMaxAge old, which defaults to 5 minutes.
+	SnapshotFile   string
+	SnapshotMaxAge time.Duration
+	// Upstreams are the upstreams whose combined stats /healthz
+	// reports when the request names no host, for setups whose zone
+	// names do not map to hosts.
+	Upstreams []string
+}
+
+// RunServer runs the health server configured with LoadServerConfig
+// from the file named by NGINX_HEALTHZ_CONFIG, if any, and the
+// environment variables.
 func RunServer() error {
-	fmt.Println("service start")
-	if err := run(); err != nil {
+	cfg, err := LoadServerConfig("")
+	if err != nil {
 		return err
 	}
+	return RunServerWithConfig(context.Background(), cfg)
+}
+
+// LoadServerConfig returns the health server configuration read from the
+// file at path, or from the file named by NGINX_HEALTHZ_CONFIG when path
+// is empty, with the environment variables that are set overriding its
+// values:
+//
+//	NGINX_HEALTHZ_LISTEN_ADDR           ListenAddr
+//	NGINX_HEALTHZ_NGINX_URL             NGINXBaseURL
+//	NGINX_HEALTHZ_MAX_CONCURRENT_HOSTS  MaxConcurrentHosts
+//	NGINX_API_VERSION                   APIVersion
+//
+// Without a file, the configuration comes from the environment alone.
+// The file may leave out nginx_url when the environment sets it. Callers
+// with command line flags apply the flags given explicitly on top, so
+// the precedence is file, then environment, then flags.
+func LoadServerConfig(path string) (ServerConfig, error) {
+	if path == "" {
+		path = os.Getenv("NGINX_HEALTHZ_CONFIG")
+	}
+	var cfg ServerConfig
+	if path != "" {
+		var err error
+		cfg, err = readConfig(path, false)
+		if err != nil {
+			return ServerConfig{}, err
+		}
+	}
+	if v := os.Getenv("NGINX_HEALTHZ_LISTEN_ADDR"); v != "" {
+		cfg.ListenAddr = v
+	}
+	if v := os.Getenv("NGINX_HEALTHZ_NGINX_URL"); v != "" {
+		cfg.NGINXBaseURL = v
+	}
+	if v := os.Getenv("NGINX_HEALTHZ_MAX_CONCURRENT_HOSTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return ServerConfig{}, fmt.Errorf("invalid NGINX_HEALTHZ_MAX_CONCURRENT_HOSTS: %w", err)
+		}
+		cfg.MaxConcurrentHosts = n
+	}
+	v, err := VersionFromEnv()
+	switch {
+	case err == nil:
+		cfg.APIVersion = v
+	case !errors.Is(err, ErrVersionNotSet):
+		return ServerConfig{}, err
+	}
+	return cfg, nil
+}
+
+// versionEnv is the environment variable holding the NGINX API version.
+const versionEnv = "NGINX_API_VERSION"
+
+// ErrVersionNotSet is returned by VersionFromEnv when the
+// NGINX_API_VERSION environment variable is unset or empty.
+var ErrVersionNotSet = errors.New(versionEnv + " is not set")
+
+// VersionFromEnv returns the NGINX API version set in the
+// NGINX_API_VERSION environment variable. It fails when the
+// variable is unset, not a number or not a supported version, rather
+// than falling back to the default version.
+func VersionFromEnv() (int, error) {
+	v := os.Getenv(versionEnv)
+	if v == "" {
+		return 0, ErrVersionNotSet
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: not a number", versionEnv, v)
+	}
+	if n < minVersion {
+		return 0, fmt.Errorf("invalid %s %d: the lowest supported version is %d", versionEnv, n, minVersion)
+	}
+	return n, nil
+}
+
+// RunServerWithConfig runs the health server until ctx is cancelled.
+func RunServerWithConfig(ctx context.Context, cfg ServerConfig) error {
+	if cfg.ListenAddr == "" {
+		cfg.ListenAddr = ":8080"
+	}
+	c, err := newServerClient(cfg)
+	if err != nil {
+		return err
+	}
+	if err := checkAPIVersion(ctx, c); err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Addr:         cfg.ListenAddr,
+		Handler:      NewServerHandler(c, cfg),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		// Requests share ctx, so long-lived streams such as
+		// /events end when the server shuts down.
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			return err
+		}
+		if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}
+}
+
+// newServerClient returns the client used by the health server.
+func newServerClient(cfg ServerConfig) (*Client, error) {
+	var opts []option
+	if cfg.APIVersion != 0 {
+		opts = append(opts, WithVersion(cfg.APIVersion))
+	}
+	if cfg.Username != "" {
+		opts = append(opts, WithBasicAuth(cfg.Username, cfg.Password))
+	}
+	if cfg.BearerToken != "" {
+		opts = append(opts, WithBearerToken(cfg.BearerToken))
+	}
+	if cfg.DrainingCountsAsUp {
+		opts = append(opts, WithDrainingCountsAsUp())
+	}
+	if cfg.SnapshotFile != "" {
+		opts = append(opts, WithSnapshotFile(cfg.SnapshotFile))
+	}
+	c, err := NewClient(cfg.NGINXBaseURL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating client: %w", err)
+	}
+	return c, nil
+}
+
+// CheckConfig checks that the health server configured by cfg can reach
+// the NGINX API and that NGINX supports the configured API version. On
+// success it writes one key=value pair per line to w: the API version
+// used, the latest version NGINX supports and the number of upstreams.
+func CheckConfig(ctx context.Context, cfg ServerConfig, w io.Writer) error {
+	c, err := newServerClient(cfg)
+	if err != nil {
+		return err
+	}
+	if err := c.Ping(ctx); err != nil {
+		return err
+	}
+	latest, err := c.DiscoverVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("discovering NGINX API version: %w", err)
+	}
+	names, err := c.upstreamNames(ctx, c.protocol.String())
+	if err != nil {
+		return fmt.Errorf("listing upstreams: %w", err)
+	}
+	_, err = fmt.Fprintf(w, "version=%d\nlatest_version=%d\nupstreams=%d\n", c.Version(), latest, len(names))
+	return err
+}
+
+// ErrPeersDown is returned by PrintStats when
+// any peer of the upstream is not up.
+var ErrPeersDown = errors.New("peers are not up")
+
+// PrintStats writes the stats of the upstream to w, one key=value pair
+// per line, using the NGINX API configured by cfg. With listDown, the
+// server address of each peer that is not up follows on a down_peer line.
+// It returns an error wrapping ErrPeersDown when any peer is not up.
+func PrintStats(ctx context.Context, cfg ServerConfig, upstream string, w io.Writer, listDown bool) error {
+	c, err := newServerClient(cfg)
+	if err != nil {
+		return err
+	}
+	res, err := c.getUpstream(ctx, upstream)
+	if err != nil {
+		return err
+	}
+	stats, err := c.calculateStatsFor(upstream, res)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "total=%d\nup=%d\ndown=%d\n", stats.Total, stats.Up, stats.Down); err != nil {
+		return err
+	}
+	if listDown {
+		for _, p := range res.Peers {
+			if c.isUp(p.State) {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "down_peer=%s\n", p.Server); err != nil {
+				return err
+			}
+		}
+	}
+	if stats.Up < stats.Total {
+		return fmt.Errorf("upstream %s: %d of %d %w", upstream, stats.Total-stats.Up, stats.Total, ErrPeersDown)
+	}
 	return nil
 }
 
-func run() error {
+// checkAPIVersion fails when NGINX does not support the configured API
+// version. An unreachable NGINX is only logged, so the server can start
+// before NGINX does.
+func checkAPIVersion(ctx context.Context, c *Client) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	err := c.Ping(ctx)
+	switch {
+	case errors.Is(err, ErrUnsupportedVersion):
+		return err
+	case err != nil:
+		c.loggerFor(ctx).Warn("checking NGINX API version", "error", err)
+	}
 	return nil
 }
+
+// NewServerHandler returns the handler serving the health server endpoints.
+//
+// GET /healthz?host=<hostname>[&host=<hostname>...] reports the stats of
+// each host, responding 200 when all hosts are healthy according to
+// cfg.HealthPolicy and 503 otherwise. The stats are sent as JSON when
+// the request accepts application/json. Without a host, the combined
+// stats of cfg.Upstreams are checked instead, and the response is 503
+// when any of them fails.
+//
+// GET /events?host=<hostname> streams the stats of the host as
+// Server-Sent Events, sending an event whenever the stats change.
+//
+// GET /livez responds 200 when the NGINX API responds and 503 otherwise,
+// for use as a Kubernetes liveness probe. An upstream outage does not fail it.
+//
+// GET /readyz[?host=<hostname>...] works like /healthz, checking
+// cfg.ReadyHosts when no host is given, for use as a readiness probe.
+//
+// GET /metrics exposes the peer stats of the configured upstreams
+// in the Prometheus format.
+func NewServerHandler(c *Client, cfg ServerConfig) http.Handler {
+	s := &server{
+		client:         c,
+		eventsInterval: cfg.EventsInterval,
+		healthPolicy:   cfg.HealthPolicy,
+		readyHosts:     cfg.ReadyHosts,
+		upstreams:      cfg.Upstreams,
+		snapshotMaxAge: cfg.SnapshotMaxAge,
+	}
+	if s.snapshotMaxAge <= 0 {
+		s.snapshotMaxAge = defaultSnapshotMaxAge
+	}
+	if s.eventsInterval <= 0 {
+		s.eventsInterval = 5 * time.Second
+	}
+	if cfg.CacheTTL > 0 || cfg.NegativeCacheTTL > 0 {
+		s.cache = newHostCache(s.scrapeHost, cfg.CacheTTL, cfg.NegativeCacheTTL, c.clock)
+	}
+	if cfg.MaxConcurrentHosts > 0 {
+		s.hostSlots = make(chan struct{}, cfg.MaxConcurrentHosts)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/events", s.handleEvents)
+	mux.HandleFunc("/livez", s.handleLivez)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	if col := metricsCollector(c, cfg); col != nil {
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(col)
+		mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	}
+	return mux
+}
+
+// NewHealthHandler returns a handler reporting the stats of the host.
+// On GET it responds 200 when the host is healthy and 503 otherwise.
+// By default the host is healthy when all of its peers are up. The body
+// holds the host and its stats as JSON when the request accepts
+// application/json, and the status text otherwise.
+func NewHealthHandler(c *Client, hostname string, opts ...healthHandlerOption) http.Handler {
+	var policy HealthPolicy
+	for _, opt := range opts {
+		opt(&policy)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		stats, err := c.GetStatsForHost(r.Context(), hostname)
+		if err != nil {
+			writeStatus(w, r, http.StatusServiceUnavailable, hostHealth{Host: hostname, Error: err.Error()})
+			return
+		}
+		code := http.StatusOK
+		if !policy.Healthy(stats) {
+			code = http.StatusServiceUnavailable
+		}
+		writeStatus(w, r, code, hostHealth{Host: hostname, Stats: stats})
+	})
+}
+
+func metricsCollector(c *Client, cfg ServerConfig) *Collector {
+	switch {
+	case len(cfg.MetricsUpstreams) > 0:
+		return NewCollector(c, cfg.MetricsUpstreams...)
+	case cfg.MetricsHost != "":
+		return NewHostCollector(c, cfg.MetricsHost)
+	}
+	return nil
+}
+
+type hostHealth struct {
+	Host string `json:"host"`
+	Stats
+	Error string `json:"error,omitempty"`
+}
+
+type server struct {
+	client         *Client
+	eventsInterval time.Duration
+	// hostSlots is a semaphore limiting concurrent host scrapes.
+	// A nil channel means no limit.
+	hostSlots    chan struct{}
+	cache        *HostCache
+	healthPolicy HealthPolicy
+	readyHosts   []string
+	upstreams    []string
+	// snapshotMaxAge bounds the age of the stats loaded from
+	// the snapshot file that stand in for a failed scrape.
+	snapshotMaxAge time.Duration
+}
+
+const defaultSnapshotMaxAge = 5 * time.Minute
+
+type upstreamsHealth struct {
+	Upstreams []string `json:"upstreams"`
+	Stats
+	Error string `json:"error,omitempty"`
+}
+
+type hostStatus struct {
+	Stats *Stats `json:"stats,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Stale is set when scraping the host failed and Stats hold the
+	// stats loaded from the snapshot file, collected at CollectedAt.
+	Stale       bool       `json:"stale,omitempty"`
+	CollectedAt *time.Time `json:"collected_at,omitempty"`
+}
+
+func (s *server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	hosts := r.URL.Query()["host"]
+	if len(hosts) == 0 && len(s.upstreams) > 0 {
+		s.checkUpstreams(w, r)
+		return
+	}
+	if len(hosts) == 0 {
+		http.Error(w, "missing host parameter", http.StatusBadRequest)
+		return
+	}
+	s.checkHosts(w, r, hosts)
+}
+
+// checkUpstreams responds with the combined stats of the configured
+// upstreams, which are healthy when all of them were queried and the
+// stats meet the health policy.
+func (s *server) checkUpstreams(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.client.GetStatsForUpstreams(r.Context(), s.upstreams)
+	res := upstreamsHealth{Upstreams: s.upstreams, Stats: stats}
+	code := http.StatusOK
+	if err != nil {
+		res.Error = err.Error()
+		code = http.StatusServiceUnavailable
+	}
+	if !s.healthPolicy.Healthy(stats) {
+		code = http.StatusServiceUnavailable
+	}
+	writeStatus(w, r, code, res)
+}
+
+// handleReadyz works like handleHealthz, checking the configured
+// ready hosts when the request names none.
+func (s *server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	hosts := r.URL.Query()["host"]
+	if len(hosts) == 0 {
+		hosts = s.readyHosts
+	}
+	if len(hosts) == 0 {
+		http.Error(w, "missing host parameter", http.StatusBadRequest)
+		return
+	}
+	s.checkHosts(w, r, hosts)
+}
+
+// handleLivez reports whether the NGINX API responds at all,
+// regardless of the health of any upstream.
+func (s *server) handleLivez(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.client.ping(r.Context()); err != nil {
+		writeStatus(w, r, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+		return
+	}
+	writeStatus(w, r, http.StatusOK, map[string]string{})
+}
+
+// checkHosts responds with the status of the hosts, which is healthy
+// when all of them meet the health policy. A host that fails to be
+// scraped is unhealthy, unless the server is warming up from the
+// snapshot file and holds recent enough stats for it.
+func (s *server) checkHosts(w http.ResponseWriter, r *http.Request, hosts []string) {
+	statuses := make(map[string]hostStatus, len(hosts))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(len(hosts))
+	for _, h := range hosts {
+		go func(host string) {
+			defer wg.Done()
+			stats, err := s.statsForHost(r.Context(), host)
+			st := hostStatus{Stats: &stats}
+			if err != nil {
+				st = hostStatus{Error: err.Error()}
+				if last, at, ok := s.client.warmUpStatsForHost(host, s.snapshotMaxAge); ok {
+					st.Stats, st.Stale, st.CollectedAt = &last, true, &at
+				}
+			}
+			mu.Lock()
+			statuses[host] = st
+			mu.Unlock()
+		}(h)
+	}
+	wg.Wait()
+
+	code := http.StatusOK
+	for _, st := range statuses {
+		if st.Stats == nil || !s.healthPolicy.Healthy(*st.Stats) {
+			code = http.StatusServiceUnavailable
+		}
+	}
+	writeStatus(w, r, code, statuses)
+}
+
+type statsEvent struct {
+	Host  string    `json:"host"`
+	Time  time.Time `json:"time"`
+	Stats *Stats    `json:"stats,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	host := r.URL.Query().Get("host")
+	if host == "" {
+		http.Error(w, "missing host parameter", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// The request context is done when the client disconnects
+	// or the server shuts down, which stops the poller. Polling goes
+	// through statsForHost, so open streams share the host cache and
+	// count against MaxConcurrentHosts like /healthz requests.
+	ctx := r.Context()
+	events := NewPoller(s.client, s.eventsInterval, []string{host}, withHostStats(s.statsForHost)).Start(ctx)
+
+	var last *statsEvent
+	for e := range events {
+		se := statsEvent{Host: e.Host, Time: e.Time}
+		if e.Err != nil {
+			se.Error = e.Err.Error()
+		} else {
+			stats := e.Stats
+			se.Stats = &stats
+		}
+		if last != nil && sameStatsEvent(*last, se) {
+			continue
+		}
+		last = &se
+
+		data, err := json.Marshal(se)
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func sameStatsEvent(a, b statsEvent) bool {
+	if a.Error != b.Error || (a.Stats == nil) != (b.Stats == nil) {
+		return false
+	}
+	return a.Stats == nil || *a.Stats == *b.Stats
+}
+
+// statsForHost returns cached stats for the host, if any,
+// and otherwise scrapes the host once a slot is available.
+func (s *server) statsForHost(ctx context.Context, host string) (Stats, error) {
+	if s.cache != nil {
+		return s.cache.GetStatsForHost(ctx, host)
+	}
+	return s.scrapeHost(ctx, host)
+}
+
+func (s *server) scrapeHost(ctx context.Context, host string) (Stats, error) {
+	if s.hostSlots != nil {
+		select {
+		case s.hostSlots <- struct{}{}:
+			defer func() { <-s.hostSlots }()
+		case <-ctx.Done():
+			return Stats{}, ctx.Err()
+		}
+	}
+	return s.client.GetStatsForHost(ctx, host)
+}
+
+// writeStatus writes v as JSON when the request accepts it, and only
+// the status text otherwise, which is all simple probes look at.
+func writeStatus(w http.ResponseWriter, r *http.Request, code int, v interface{}) {
+	if acceptsJSON(r) {
+		writeJSON(w, code, v)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	fmt.Fprintln(w, http.StatusText(code))
+}
+
+func acceptsJSON(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(v, ",") {
+			mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err == nil && mediaType == "application/json" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -1 +1,749 @@
 package nginxhealthz_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+	"github.com/qba73/nginx-healthz/nginxhealthztest"
+)
+
+// newTestNGINX returns a fake NGINX API serving the bar.example.org
+// host with the hg-backend and lxr-backend upstreams.
+func newTestNGINX(t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/http/upstreams"):
+			io.WriteString(w, validResponseGetUpstreamsZones)
+		case strings.HasSuffix(r.URL.Path, "/lxr-backend"):
+			io.WriteString(w, validResponseUpstreamLXRbackend)
+		default:
+			io.WriteString(w, validResponseUpstreamHGbackend)
+		}
+	}))
+}
+
+// newJSONRequest returns a GET request accepting JSON responses.
+func newJSONRequest(target string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	r.Header.Set("Accept", "application/json")
+	return r
+}
+
+func TestServerHealthz_ReportsStatsPerHost(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := nginxhealthz.NewServerHandler(c, nginxhealthz.ServerConfig{})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, newJSONRequest("/healthz?host=bar.example.org"))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("want status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+	var got map[string]struct {
+		Stats nginxhealthz.Stats `json:"stats"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.Stats{Total: 4, Up: 3, Down: 1}
+	if !cmp.Equal(want, got["bar.example.org"].Stats) {
+		t.Error(cmp.Diff(want, got["bar.example.org"].Stats))
+	}
+}
+
+func TestServerHealthz_SendsOnlyStatusWithoutJSONAccept(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := nginxhealthz.NewServerHandler(c, nginxhealthz.ServerConfig{})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz?host=bar.example.org", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("want status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("want plain text response, got %q", ct)
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != "Service Unavailable" {
+		t.Errorf("want status text body, got %q", got)
+	}
+}
+
+func TestHealthHandler_IncludesHostnameInJSON(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/html, application/json;q=0.9")
+	nginxhealthz.NewHealthHandler(c, "bar.example.org").ServeHTTP(rec, req)
+
+	var got struct {
+		Host  string `json:"host"`
+		Total int    `json:"total"`
+		Up    int    `json:"up"`
+		Down  int    `json:"down"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Host != "bar.example.org" || got.Total != 4 || got.Up != 3 || got.Down != 1 {
+		t.Errorf("unexpected body %+v", got)
+	}
+}
+
+func TestServerHealthz_FailsOnMissingHost(t *testing.T) {
+	t.Parallel()
+
+	c, err := nginxhealthz.NewClient("http://localhost:9001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := nginxhealthz.NewServerHandler(c, nginxhealthz.ServerConfig{})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("want status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestServerHealthz_ReportsCombinedStatsOfConfiguredUpstreams(t *testing.T) {
+	t.Parallel()
+
+	ts := nginxhealthztest.NewFakeServer(map[string]nginxhealthz.Stats{
+		"web":   {Up: 2},
+		"api":   {Up: 1, Down: 1},
+		"other": {Down: 3},
+	})
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := nginxhealthz.NewServerHandler(c, nginxhealthz.ServerConfig{
+		Upstreams:    []string{"web", "api"},
+		HealthPolicy: nginxhealthz.HealthPolicy{MinUpFraction: 0.5},
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, newJSONRequest("/healthz"))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("want status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var got struct {
+		Upstreams []string `json:"upstreams"`
+		nginxhealthz.Stats
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.Stats{Total: 4, Up: 3, Down: 1}
+	if !cmp.Equal(want, got.Stats) {
+		t.Error(cmp.Diff(want, got.Stats))
+	}
+	if !cmp.Equal([]string{"web", "api"}, got.Upstreams) {
+		t.Errorf("unexpected upstreams %v", got.Upstreams)
+	}
+}
+
+func TestServerHealthz_ReportsUnavailableWhenConfiguredUpstreamFails(t *testing.T) {
+	t.Parallel()
+
+	ts := nginxhealthztest.NewFakeServer(map[string]nginxhealthz.Stats{
+		"web": {Up: 2},
+	})
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := nginxhealthz.NewServerHandler(c, nginxhealthz.ServerConfig{
+		Upstreams: []string{"web", "missing"},
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, newJSONRequest("/healthz"))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("want status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+	var got struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got.Error, "missing") {
+		t.Errorf("want error naming the missing upstream, got %q", got.Error)
+	}
+}
+
+func TestServerHealthz_RespectsMaxConcurrentHosts(t *testing.T) {
+	t.Parallel()
+
+	const limit = 2
+	var inFlight, maxInFlight int64
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/http/upstreams") {
+			io.WriteString(w, validResponseUpstreamHGbackend)
+			return
+		}
+		// Every host scrape starts with listing the zones,
+		// so in-flight listings equal in-flight host scrapes.
+		n := atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+		for {
+			m := atomic.LoadInt64(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt64(&maxInFlight, m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		io.WriteString(w, validResponseGetUpstreamsZones)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := nginxhealthz.NewServerHandler(c, nginxhealthz.ServerConfig{MaxConcurrentHosts: limit})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			url := fmt.Sprintf("/healthz?host=bar.example.org&host=host%d.example.org", i)
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, url, nil).WithContext(context.Background()))
+		}(i)
+	}
+	wg.Wait()
+
+	got := atomic.LoadInt64(&maxInFlight)
+	if got > limit {
+		t.Errorf("want at most %d concurrent host scrapes, got %d", limit, got)
+	}
+	if got == 0 {
+		t.Error("want hosts scraped")
+	}
+}
+
+func TestServerEvents_RespectsMaxConcurrentHosts(t *testing.T) {
+	t.Parallel()
+
+	const (
+		limit   = 1
+		streams = 5
+	)
+	var inFlight, maxInFlight, scrapes int64
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/http/upstreams") {
+			zones := make(map[string]map[string]string)
+			for i := 0; i < streams; i++ {
+				zones[fmt.Sprintf("backend%d", i)] = map[string]string{"zone": fmt.Sprintf("host%d.example.org-backend%d", i, i)}
+			}
+			json.NewEncoder(w).Encode(zones)
+			return
+		}
+		// Every host has a single upstream of its own,
+		// so in-flight upstream GETs equal in-flight host scrapes.
+		n := atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+		for {
+			m := atomic.LoadInt64(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt64(&maxInFlight, m, n) {
+				break
+			}
+		}
+		atomic.AddInt64(&scrapes, 1)
+		time.Sleep(20 * time.Millisecond)
+		io.WriteString(w, validResponseUpstreamHGbackend)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(nginxhealthz.NewServerHandler(c, nginxhealthz.ServerConfig{
+		MaxConcurrentHosts: limit,
+		EventsInterval:     time.Millisecond,
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			url := fmt.Sprintf("%s/events?host=host%d.example.org", srv.URL, i)
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			// Wait for the first event, so every stream has polled.
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				if strings.HasPrefix(scanner.Text(), "data: ") {
+					return
+				}
+			}
+			t.Errorf("stream %d ended without event: %v", i, scanner.Err())
+		}(i)
+	}
+	wg.Wait()
+	cancel()
+
+	if got := atomic.LoadInt64(&maxInFlight); got > limit {
+		t.Errorf("want at most %d concurrent host scrapes, got %d", limit, got)
+	}
+	if atomic.LoadInt64(&scrapes) < streams {
+		t.Errorf("want every host scraped, got %d scrapes", atomic.LoadInt64(&scrapes))
+	}
+}
+
+func TestServerEvents_StreamsStatsForHost(t *testing.T) {
+	t.Parallel()
+
+	nginx := newTestNGINX(t)
+	defer nginx.Close()
+
+	c, err := nginxhealthz.NewClient(nginx.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(nginxhealthz.NewServerHandler(c, nginxhealthz.ServerConfig{
+		EventsInterval: 10 * time.Millisecond,
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/events?host=bar.example.org", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("want event stream, got %q", got)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var e struct {
+			Host  string             `json:"host"`
+			Time  time.Time          `json:"time"`
+			Stats nginxhealthz.Stats `json:"stats"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+			t.Fatal(err)
+		}
+		want := nginxhealthz.Stats{Total: 4, Up: 3, Down: 1}
+		if e.Host != "bar.example.org" || e.Time.IsZero() || !cmp.Equal(want, e.Stats) {
+			t.Errorf("unexpected event %+v", e)
+		}
+		return
+	}
+	t.Fatalf("stream ended without event: %v", scanner.Err())
+}
+
+func TestRunServerWithConfig_StopsWhenContextIsCancelled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- nginxhealthz.RunServerWithConfig(ctx, nginxhealthz.ServerConfig{
+			ListenAddr:   "127.0.0.1:0",
+			NGINXBaseURL: "http://localhost:9001",
+			APIVersion:   7,
+			ReadTimeout:  time.Second,
+		})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+	}
+}
+
+func TestRunServerWithConfig_FailsOnInvalidAPIVersion(t *testing.T) {
+	t.Parallel()
+
+	err := nginxhealthz.RunServerWithConfig(context.Background(), nginxhealthz.ServerConfig{
+		ListenAddr:   "127.0.0.1:0",
+		NGINXBaseURL: "http://localhost:9001",
+		APIVersion:   1,
+	})
+	if err == nil {
+		t.Fatal("want error")
+	}
+}
+
+func TestRunServerWithConfig_FailsWhenNGINXDoesNotSupportAPIVersion(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "[4,5,6]")
+	}))
+	defer ts.Close()
+
+	err := nginxhealthz.RunServerWithConfig(context.Background(), nginxhealthz.ServerConfig{
+		ListenAddr:   "127.0.0.1:0",
+		NGINXBaseURL: ts.URL,
+		APIVersion:   8,
+	})
+	if !errors.Is(err, nginxhealthz.ErrUnsupportedVersion) {
+		t.Fatalf("want ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestHealthHandler_ReportsUnavailableWhenPeerIsDown(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/internal/nginx-healthz", nginxhealthz.NewHealthHandler(c, "bar.example.org"))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, newJSONRequest("/internal/nginx-healthz"))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("want status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+	var got nginxhealthz.Stats
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.Stats{Total: 4, Up: 3, Down: 1}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestHealthHandler_ReportsOKWhenAllPeersAreUp(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/http/upstreams") {
+			io.WriteString(w, validResponseGetUpstreamsZones)
+			return
+		}
+		io.WriteString(w, validResponseUpstreamLXRbackend)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	nginxhealthz.NewHealthHandler(c, "bar.example.org").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("want status %d, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestServerLivez_ReportsOKWhenAPIRespondsDespiteDownPeers(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := nginxhealthz.NewServerHandler(c, nginxhealthz.ServerConfig{ReadyHosts: []string{"bar.example.org"}})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("livez: want status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyz: want status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
+
+func TestServerLivez_ReportsUnavailableWhenAPIFails(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := nginxhealthz.NewServerHandler(c, nginxhealthz.ServerConfig{})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("want status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
+
+func TestServerReadyz_FailsWithoutHosts(t *testing.T) {
+	t.Parallel()
+
+	c, err := nginxhealthz.NewClient("http://localhost:9001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := nginxhealthz.NewServerHandler(c, nginxhealthz.ServerConfig{})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("want status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestVersionFromEnv_ReturnsSetVersion(t *testing.T) {
+	t.Setenv("NGINX_API_VERSION", "7")
+
+	got, err := nginxhealthz.VersionFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 7 {
+		t.Errorf("want version 7, got %d", got)
+	}
+}
+
+func TestVersionFromEnv_FailsWhenUnset(t *testing.T) {
+	t.Setenv("NGINX_API_VERSION", "")
+
+	if _, err := nginxhealthz.VersionFromEnv(); !errors.Is(err, nginxhealthz.ErrVersionNotSet) {
+		t.Errorf("want ErrVersionNotSet, got %v", err)
+	}
+}
+
+func TestVersionFromEnv_FailsOnInvalidVersion(t *testing.T) {
+	for _, v := range []string{"eight", "8.0", "3", "-1"} {
+		t.Run(v, func(t *testing.T) {
+			t.Setenv("NGINX_API_VERSION", v)
+
+			_, err := nginxhealthz.VersionFromEnv()
+			if err == nil {
+				t.Fatal("want error, got nil")
+			}
+			if errors.Is(err, nginxhealthz.ErrVersionNotSet) {
+				t.Errorf("want invalid version error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckConfig_ReportsVersionsAndUpstreamCount(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/":
+			io.WriteString(w, "[4,5,6,7,8,9]")
+		case "/api/8/http/upstreams":
+			io.WriteString(w, validResponseGetUpstreamsZones)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	err := nginxhealthz.CheckConfig(context.Background(), nginxhealthz.ServerConfig{NGINXBaseURL: ts.URL}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "version=8\nlatest_version=9\nupstreams=4\n"
+	if got := buf.String(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestCheckConfig_FailsWhenNGINXDoesNotSupportAPIVersion(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "[4,5,6]")
+	}))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	err := nginxhealthz.CheckConfig(context.Background(), nginxhealthz.ServerConfig{NGINXBaseURL: ts.URL}, &buf)
+	if !errors.Is(err, nginxhealthz.ErrUnsupportedVersion) {
+		t.Fatalf("want ErrUnsupportedVersion, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("want no output, got %q", buf.String())
+	}
+}
+
+func TestCheckConfig_FailsOnInvalidNGINXURL(t *testing.T) {
+	t.Parallel()
+
+	err := nginxhealthz.CheckConfig(context.Background(), nginxhealthz.ServerConfig{NGINXBaseURL: "localhost:9001"}, io.Discard)
+	if err == nil {
+		t.Fatal("want error, got nil")
+	}
+}
+
+func TestPrintStats_ReportsDownPeersAndFails(t *testing.T) {
+	t.Parallel()
+
+	ts := nginxhealthztest.NewFakeServer(map[string]nginxhealthz.Stats{
+		"demo-backend": {Up: 1, Down: 1},
+	})
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	err := nginxhealthz.PrintStats(context.Background(), nginxhealthz.ServerConfig{NGINXBaseURL: ts.URL}, "demo-backend", &buf, true)
+	if !errors.Is(err, nginxhealthz.ErrPeersDown) {
+		t.Errorf("want ErrPeersDown, got %v", err)
+	}
+	want := "total=2\nup=1\ndown=1\ndown_peer=10.0.0.1:80\n"
+	if got := buf.String(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestPrintStats_SucceedsWhenAllPeersAreUp(t *testing.T) {
+	t.Parallel()
+
+	ts := nginxhealthztest.NewFakeServer(map[string]nginxhealthz.Stats{
+		"demo-backend": {Up: 2},
+	})
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	err := nginxhealthz.PrintStats(context.Background(), nginxhealthz.ServerConfig{NGINXBaseURL: ts.URL}, "demo-backend", &buf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "total=2\nup=2\ndown=0\n"
+	if got := buf.String(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestPrintStats_DoesNotListDrainingPeersWhenTheyCountAsUp(t *testing.T) {
+	t.Parallel()
+
+	ts := nginxhealthztest.NewFakeServer(map[string]nginxhealthz.Stats{
+		"demo-backend": {Up: 1, Draining: 1},
+	})
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	cfg := nginxhealthz.ServerConfig{NGINXBaseURL: ts.URL, DrainingCountsAsUp: true}
+	err := nginxhealthz.PrintStats(context.Background(), cfg, "demo-backend", &buf, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "total=2\nup=2\ndown=0\n"
+	if got := buf.String(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestPrintStats_FailsOnUnknownUpstream(t *testing.T) {
+	t.Parallel()
+
+	ts := nginxhealthztest.NewFakeServer(map[string]nginxhealthz.Stats{
+		"demo-backend": {Up: 2},
+	})
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	err := nginxhealthz.PrintStats(context.Background(), nginxhealthz.ServerConfig{NGINXBaseURL: ts.URL}, "missing", &buf, false)
+	if !errors.Is(err, nginxhealthz.ErrUpstreamNotFound) {
+		t.Errorf("want ErrUpstreamNotFound, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("want nothing written, got %q", buf.String())
+	}
+}
@@ -0,0 +1,7 @@
+package nginxhealthz
+
+// SetSharedJoinedHook makes the client call fn whenever a caller starts
+// waiting for a shared GET, so tests can tell when all callers joined.
+func SetSharedJoinedHook(c *Client, fn func()) {
+	c.sharedJoined = fn
+}
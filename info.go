@@ -0,0 +1,32 @@
+package nginxhealthz
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Info describes the NGINX instance behind the API.
+type Info struct {
+	Version    string `json:"version"`
+	Build      string `json:"build"`
+	Address    string `json:"address"`
+	Generation int64  `json:"generation"`
+	// LoadTimestamp is the time of the last configuration
+	// load or reload.
+	LoadTimestamp time.Time `json:"load_timestamp"`
+	// Timestamp is the current time reported by NGINX.
+	Timestamp time.Time `json:"timestamp"`
+	PID       int       `json:"pid"`
+	PPID      int       `json:"ppid"`
+}
+
+// GetInfo returns the version, build and the configuration
+// load time of the NGINX instance.
+func (c *Client) GetInfo(ctx context.Context) (Info, error) {
+	var info Info
+	if err := c.getAPI(ctx, "/nginx", &info); err != nil {
+		return Info{}, fmt.Errorf("retrieving NGINX info: %w", err)
+	}
+	return info, nil
+}
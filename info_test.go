@@ -0,0 +1,70 @@
+package nginxhealthz_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+const validResponseNginxInfo = `{
+  "version": "1.25.3",
+  "build": "nginx-plus-r31",
+  "address": "10.0.0.1",
+  "generation": 6,
+  "load_timestamp": "2024-03-01T10:20:30.123Z",
+  "timestamp": "2024-03-01T12:00:00.000Z",
+  "pid": 32212,
+  "ppid": 32210
+}`
+
+func TestGetInfo_ReturnsNGINXInstanceInfo(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(validResponseNginxInfo, "/api/8/nginx", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.Info{
+		Version:       "1.25.3",
+		Build:         "nginx-plus-r31",
+		Address:       "10.0.0.1",
+		Generation:    6,
+		LoadTimestamp: time.Date(2024, 3, 1, 10, 20, 30, 123000000, time.UTC),
+		Timestamp:     time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		PID:           32212,
+		PPID:          32210,
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetInfo_FailsOnErrorResponse(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetInfo(context.Background()); err == nil {
+		t.Error("want error, got nil")
+	}
+}
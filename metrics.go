@@ -0,0 +1,89 @@
+package nginxhealthz
+
+import (
+	"context"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	peersUpDesc = prometheus.NewDesc(
+		"nginx_upstream_peers_up",
+		"Number of upstream peers in the up state.",
+		[]string{"upstream"}, nil,
+	)
+	peersDownDesc = prometheus.NewDesc(
+		"nginx_upstream_peers_down",
+		"Number of upstream peers in the down state.",
+		[]string{"upstream"}, nil,
+	)
+	peersTotalDesc = prometheus.NewDesc(
+		"nginx_upstream_peers_total",
+		"Total number of upstream peers.",
+		[]string{"upstream"}, nil,
+	)
+)
+
+// Collector is a prometheus.Collector exporting the peer stats of
+// upstreams, queried from the NGINX API on every scrape.
+type Collector struct {
+	client    *Client
+	upstreams func(ctx context.Context) ([]string, error)
+}
+
+// NewCollector returns a collector exporting the stats of the upstreams.
+func NewCollector(c *Client, upstreams ...string) *Collector {
+	return &Collector{
+		client: c,
+		upstreams: func(context.Context) ([]string, error) {
+			return upstreams, nil
+		},
+	}
+}
+
+// NewHostCollector returns a collector exporting the stats of the
+// upstreams of the host, discovered on every scrape among the upstreams
+// of the client protocol.
+func NewHostCollector(c *Client, hostname string) *Collector {
+	return &Collector{
+		client: c,
+		upstreams: func(ctx context.Context) ([]string, error) {
+			res, err := c.hostUpstreams(ctx, hostname)
+			if err != nil {
+				return nil, err
+			}
+			upstreams := res[hostname]
+			sort.Strings(upstreams)
+			return upstreams, nil
+		},
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (col *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- peersUpDesc
+	ch <- peersDownDesc
+	ch <- peersTotalDesc
+}
+
+// Collect implements prometheus.Collector. Upstreams failing to
+// be queried are logged and left out of the scrape.
+func (col *Collector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+	upstreams, err := col.upstreams(ctx)
+	if err != nil {
+		col.client.logger.Warn("discovering upstreams for metrics", "error", err)
+		return
+	}
+	for _, u := range upstreams {
+		stats, err := col.client.GetStatsFor(ctx, u)
+		if err != nil {
+			col.client.logger.Warn("collecting upstream metrics", "upstream", u, "error", err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(peersUpDesc, prometheus.GaugeValue, float64(stats.Up), u)
+		ch <- prometheus.MustNewConstMetric(peersDownDesc, prometheus.GaugeValue, float64(stats.Down), u)
+		ch <- prometheus.MustNewConstMetric(peersTotalDesc, prometheus.GaugeValue, float64(stats.Total), u)
+	}
+}
@@ -0,0 +1,113 @@
+package nginxhealthz_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+func scrapeMetrics(t *testing.T, h http.Handler) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want status %d, got %d", http.StatusOK, rec.Code)
+	}
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestServerMetrics_ExportsGaugesForConfiguredUpstreams(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := nginxhealthz.NewServerHandler(c, nginxhealthz.ServerConfig{
+		MetricsUpstreams: []string{"hg-backend"},
+	})
+
+	got := scrapeMetrics(t, h)
+	for _, want := range []string{
+		`nginx_upstream_peers_up{upstream="hg-backend"} 1`,
+		`nginx_upstream_peers_down{upstream="hg-backend"} 1`,
+		`nginx_upstream_peers_total{upstream="hg-backend"} 2`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("want %q in metrics:\n%s", want, got)
+		}
+	}
+}
+
+func TestServerMetrics_DiscoversUpstreamsForHost(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := nginxhealthz.NewServerHandler(c, nginxhealthz.ServerConfig{
+		MetricsHost: "bar.example.org",
+	})
+
+	got := scrapeMetrics(t, h)
+	for _, want := range []string{
+		`nginx_upstream_peers_total{upstream="hg-backend"} 2`,
+		`nginx_upstream_peers_total{upstream="lxr-backend"} 2`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("want %q in metrics:\n%s", want, got)
+		}
+	}
+}
+
+func TestServerMetrics_DiscoversStreamUpstreamsWithStreamProtocol(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestStreamNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithProtocol(nginxhealthz.ProtocolStream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := nginxhealthz.NewServerHandler(c, nginxhealthz.ServerConfig{
+		MetricsHost: "bar.example.org",
+	})
+
+	got := scrapeMetrics(t, h)
+	want := `nginx_upstream_peers_total{upstream="mysql-backend"} 2`
+	if !strings.Contains(got, want) {
+		t.Errorf("want %q in metrics:\n%s", want, got)
+	}
+}
+
+func TestServerMetrics_NotServedWithoutUpstreams(t *testing.T) {
+	t.Parallel()
+
+	c, err := nginxhealthz.NewClient("http://localhost:9001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := nginxhealthz.NewServerHandler(c, nginxhealthz.ServerConfig{})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("want status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
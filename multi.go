@@ -0,0 +1,147 @@
+package nginxhealthz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// InstancePolicy selects how MultiClient combines the peers of an
+// upstream configured on several NGINX instances.
+type InstancePolicy int
+
+const (
+	// SumInstances adds up the stats of every instance, so a peer
+	// of an upstream on three instances is counted three times.
+	SumInstances InstancePolicy = iota
+	// PeerUpOnAnyInstance counts every peer once, identified as
+	// configured with WithPeerIdentity, and as up when it is up on
+	// any instance.
+	PeerUpOnAnyInstance
+	// PeerUpOnAllInstances counts every peer once, and as up only
+	// when it is up on all instances reporting it.
+	PeerUpOnAllInstances
+)
+
+// MultiClient queries the same upstreams on several NGINX instances.
+type MultiClient struct {
+	clients []*Client
+	// Policy selects how GetStatsFor combines the instances.
+	// The zero value is SumInstances.
+	Policy InstancePolicy
+}
+
+// NewMultiClient returns a client for the NGINX instances at the base
+// URLs. The options apply to the client of every instance.
+func NewMultiClient(baseURLs []string, opts ...option) (*MultiClient, error) {
+	if len(baseURLs) == 0 {
+		return nil, errors.New("no base URLs")
+	}
+	m := &MultiClient{clients: make([]*Client, 0, len(baseURLs))}
+	for _, u := range baseURLs {
+		c, err := NewClient(u, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("creating client for %s: %w", u, err)
+		}
+		m.clients = append(m.clients, c)
+	}
+	return m, nil
+}
+
+// InstanceResult holds the outcome of querying a single instance.
+type InstanceResult struct {
+	BaseURL string
+	Stats   Stats
+	Err     error
+}
+
+// GetStatsPerInstance returns the stats of the upstream on each
+// instance, in the order of the base URLs.
+func (m *MultiClient) GetStatsPerInstance(ctx context.Context, upstream string) []InstanceResult {
+	results := make([]InstanceResult, len(m.clients))
+	m.forEach(func(i int, c *Client) {
+		stats, err := c.GetStatsFor(ctx, upstream)
+		results[i] = InstanceResult{BaseURL: c.baseURL, Stats: stats, Err: err}
+	})
+	return results
+}
+
+// GetStatsFor returns the stats of the upstream combined across
+// instances according to the policy. Instances failing to be queried
+// are left out and their errors joined in the returned error.
+func (m *MultiClient) GetStatsFor(ctx context.Context, upstream string) (Stats, error) {
+	if m.Policy == SumInstances {
+		var stats Stats
+		var errs []error
+		for _, r := range m.GetStatsPerInstance(ctx, upstream) {
+			if r.Err != nil {
+				errs = append(errs, fmt.Errorf("instance %s: %w", r.BaseURL, r.Err))
+				continue
+			}
+			stats = stats.add(r.Stats)
+		}
+		return stats, errors.Join(errs...)
+	}
+
+	peers := make([][]Peer, len(m.clients))
+	errs := make([]error, len(m.clients))
+	m.forEach(func(i int, c *Client) {
+		peers[i], errs[i] = c.GetPeersFor(ctx, upstream)
+		if errs[i] != nil {
+			errs[i] = fmt.Errorf("instance %s: %w", c.baseURL, errs[i])
+		}
+	})
+	return m.mergePeers(peers), errors.Join(errs...)
+}
+
+// mergePeers counts every peer once, combining its states
+// on the instances according to the policy.
+func (m *MultiClient) mergePeers(instances [][]Peer) Stats {
+	c := m.clients[0]
+	type merged struct {
+		backup bool
+		state  string
+	}
+	var order []string
+	peers := make(map[string]*merged)
+	for _, ps := range instances {
+		for _, p := range ps {
+			id := c.peerID(p.Server, p.Name)
+			mp, ok := peers[id]
+			if !ok {
+				peers[id] = &merged{backup: p.Backup, state: p.State}
+				order = append(order, id)
+				continue
+			}
+			switch {
+			case m.Policy == PeerUpOnAnyInstance && c.isUp(p.State) && !c.isUp(mp.state):
+				mp.state = p.State
+			case m.Policy == PeerUpOnAllInstances && !c.isUp(p.State):
+				mp.state = p.State
+			}
+		}
+	}
+	var stats Stats
+	for _, id := range order {
+		c.countPeer(&stats, peers[id].backup, peers[id].state)
+	}
+	return stats
+}
+
+// forEach calls fn for every instance concurrently, querying at most
+// as many instances at the same time as set with WithMaxConcurrency.
+func (m *MultiClient) forEach(fn func(i int, c *Client)) {
+	sem := make(chan struct{}, m.clients[0].maxConcurrency)
+	var wg sync.WaitGroup
+	wg.Add(len(m.clients))
+	for i, c := range m.clients {
+		go func(i int, c *Client) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			fn(i, c)
+		}(i, c)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,106 @@
+package nginxhealthz_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+func newTestInstance(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+}
+
+func newTestMultiClient(t *testing.T) *nginxhealthz.MultiClient {
+	t.Helper()
+	a := newTestInstance(t, `{"peers": [
+		{"server": "10.0.0.1:80", "state": "up"},
+		{"server": "10.0.0.2:80", "state": "down"}
+	]}`)
+	t.Cleanup(a.Close)
+	b := newTestInstance(t, `{"peers": [
+		{"server": "10.0.0.1:80", "state": "unavail"},
+		{"server": "10.0.0.2:80", "state": "up"}
+	]}`)
+	t.Cleanup(b.Close)
+
+	m, err := nginxhealthz.NewMultiClient([]string{a.URL, b.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMultiClient_GetStatsForCombinesInstancesByPolicy(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		policy nginxhealthz.InstancePolicy
+		want   nginxhealthz.Stats
+	}{
+		{policy: nginxhealthz.SumInstances, want: nginxhealthz.Stats{Total: 4, Up: 2, Down: 1, Unavail: 1}},
+		{policy: nginxhealthz.PeerUpOnAnyInstance, want: nginxhealthz.Stats{Total: 2, Up: 2}},
+		{policy: nginxhealthz.PeerUpOnAllInstances, want: nginxhealthz.Stats{Total: 2, Down: 1, Unavail: 1}},
+	}
+	for _, tc := range tests {
+		m := newTestMultiClient(t)
+		m.Policy = tc.policy
+		got, err := m.GetStatsFor(context.Background(), "demo-backend")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cmp.Equal(tc.want, got) {
+			t.Errorf("policy %d: %s", tc.policy, cmp.Diff(tc.want, got))
+		}
+	}
+}
+
+func TestMultiClient_GetStatsPerInstanceReportsEachInstance(t *testing.T) {
+	t.Parallel()
+
+	ok := newTestInstance(t, validResponseGetUpstreamAllServersUp)
+	defer ok.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	m, err := nginxhealthz.NewMultiClient([]string{ok.URL, failing.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := m.GetStatsPerInstance(context.Background(), "demo-backend")
+	if len(got) != 2 {
+		t.Fatalf("want 2 results, got %d", len(got))
+	}
+	if got[0].BaseURL != ok.URL || got[0].Err != nil || got[0].Stats.Up != 2 {
+		t.Errorf("unexpected result for healthy instance: %+v", got[0])
+	}
+	if got[1].BaseURL != failing.URL || got[1].Err == nil {
+		t.Errorf("want error for failing instance, got %+v", got[1])
+	}
+
+	stats, err := m.GetStatsFor(context.Background(), "demo-backend")
+	if err == nil {
+		t.Error("want error naming the failing instance")
+	}
+	if stats.Up != 2 {
+		t.Errorf("want stats of the healthy instance, got %+v", stats)
+	}
+}
+
+func TestNewMultiClient_FailsWithoutBaseURLs(t *testing.T) {
+	t.Parallel()
+
+	if _, err := nginxhealthz.NewMultiClient(nil); err == nil {
+		t.Fatal("want error")
+	}
+}
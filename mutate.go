@@ -0,0 +1,78 @@
+package nginxhealthz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// PeerState is a state a peer can be switched to with SetPeerState.
+type PeerState string
+
+const (
+	PeerStateUp    PeerState = "up"
+	PeerStateDown  PeerState = "down"
+	PeerStateDrain PeerState = "drain"
+)
+
+// WithDryRun makes mutating methods, such as SetPeerState, build and
+// validate their request and log it without sending it to NGINX.
+func WithDryRun() option {
+	return func(c *Client) error {
+		c.dryRun = true
+		return nil
+	}
+}
+
+// MutationResult describes the request made by a mutating method.
+type MutationResult struct {
+	Method string
+	URL    string
+	Body   []byte
+	// DryRun is set when the request was not sent.
+	DryRun bool
+}
+
+// SetPeerState switches the peer with the given ID in the upstream to
+// the state.
+func (c *Client) SetPeerState(ctx context.Context, upstream string, id int, state PeerState) (MutationResult, error) {
+	var patch map[string]bool
+	switch state {
+	case PeerStateUp:
+		patch = map[string]bool{"down": false, "drain": false}
+	case PeerStateDown:
+		patch = map[string]bool{"down": true}
+	case PeerStateDrain:
+		patch = map[string]bool{"drain": true}
+	default:
+		return MutationResult{}, fmt.Errorf("unsupported peer state: %q", state)
+	}
+	path, err := upstreamPath(c.protocol.String(), upstream)
+	if err != nil {
+		return MutationResult{}, err
+	}
+	if id < 0 {
+		return MutationResult{}, fmt.Errorf("invalid peer ID: %d", id)
+	}
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return MutationResult{}, fmt.Errorf("encoding request body: %w", err)
+	}
+
+	path = fmt.Sprintf("%s/servers/%d", path, id)
+	res := MutationResult{
+		Method: http.MethodPatch,
+		Body:   body,
+		DryRun: c.dryRun,
+	}
+	if c.dryRun {
+		res.URL = c.apiURL(c.negotiatedVersion(ctx), path)
+		c.loggerFor(ctx).Info("dry run: not sending request", "method", res.Method, "url", res.URL, "body", string(res.Body))
+		return res, nil
+	}
+	// The request goes to the API version the reads use, including
+	// the versions found by WithAutoVersion and WithVersionFallback.
+	res.URL, err = c.doAPIVersioned(ctx, res.Method, path, json.RawMessage(res.Body), nil)
+	return res, c.withRequestID(ctx, err)
+}
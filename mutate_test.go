@@ -0,0 +1,178 @@
+package nginxhealthz_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+func TestSetPeerState_SendsPatchRequest(t *testing.T) {
+	t.Parallel()
+
+	var gotMethod, gotPath, gotBody string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod, gotPath = r.Method, r.URL.Path
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotBody = string(b)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := c.SetPeerState(context.Background(), "demo-backend", 1, nginxhealthz.PeerStateDrain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.DryRun {
+		t.Error("want request sent")
+	}
+	if gotMethod != http.MethodPatch || gotPath != "/api/8/http/upstreams/demo-backend/servers/1" {
+		t.Errorf("unexpected request %s %s", gotMethod, gotPath)
+	}
+	if gotBody != `{"drain":true}` {
+		t.Errorf("unexpected body %s", gotBody)
+	}
+}
+
+func TestSetPeerState_DoesNotSendRequestInDryRun(t *testing.T) {
+	t.Parallel()
+
+	var called bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithDryRun())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.SetPeerState(context.Background(), "demo-backend", 0, nginxhealthz.PeerStateDown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if called {
+		t.Error("request sent in dry-run mode")
+	}
+	want := nginxhealthz.MutationResult{
+		Method: http.MethodPatch,
+		URL:    ts.URL + "/api/8/http/upstreams/demo-backend/servers/0",
+		Body:   []byte(`{"down":true}`),
+		DryRun: true,
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestSetPeerState_FailsOnInvalidStateInDryRun(t *testing.T) {
+	t.Parallel()
+
+	c, err := nginxhealthz.NewClient("http://localhost:9001", nginxhealthz.WithDryRun())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.SetPeerState(context.Background(), "demo-backend", 0, "bogus"); err == nil {
+		t.Error("want error on invalid state")
+	}
+}
+
+func TestSetPeerState_UsesDiscoveredVersion(t *testing.T) {
+	t.Parallel()
+
+	var gotPath string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/":
+			io.WriteString(w, `[4,5,6,7,8,9]`)
+		case r.Method == http.MethodPatch:
+			gotPath = r.URL.Path
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithAutoVersion())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.SetPeerState(context.Background(), "demo-backend", 1, nginxhealthz.PeerStateDown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "/api/9/http/upstreams/demo-backend/servers/1"
+	if gotPath != want {
+		t.Errorf("want request to %s, got %s", want, gotPath)
+	}
+	if res.URL != ts.URL+want {
+		t.Errorf("want result URL %s, got %s", ts.URL+want, res.URL)
+	}
+}
+
+func TestSetPeerState_FallsBackToLowerVersionWhenEnabled(t *testing.T) {
+	t.Parallel()
+
+	var gotPath string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/6/":
+			io.WriteString(w, `["nginx","http"]`)
+		case r.Method == http.MethodPatch && r.URL.Path == "/api/6/http/upstreams/demo-backend/servers/1":
+			gotPath = r.URL.Path
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithVersionFallback())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.SetPeerState(context.Background(), "demo-backend", 1, nginxhealthz.PeerStateUp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotPath == "" || res.URL != ts.URL+gotPath {
+		t.Errorf("want request to version 6, got path %q and result URL %s", gotPath, res.URL)
+	}
+}
+
+func TestSetPeerState_DryRunReportsDiscoveredVersion(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/api/" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			return
+		}
+		io.WriteString(w, `[4,5,6,7]`)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithAutoVersion(), nginxhealthz.WithDryRun())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.SetPeerState(context.Background(), "demo-backend", 1, nginxhealthz.PeerStateDrain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := ts.URL + "/api/7/http/upstreams/demo-backend/servers/1"; res.URL != want {
+		t.Errorf("want URL %s, got %s", want, res.URL)
+	}
+}
@@ -0,0 +1,109 @@
+// Package nginxhealthztest provides a fake NGINX Plus API for testing
+// code that uses the nginxhealthz client.
+//
+// The fake serves the API root, the upstreams listing, including the
+// zone-only listing used by GetUpstreamsFor, and single upstreams, for any
+// API version. Upstreams are given as the Stats they should report, and
+// the fake makes up peers in the matching states.
+package nginxhealthztest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+// NewFakeServer starts a fake NGINX Plus API serving the upstreams. A key
+// of the form "hostname/upstream" places the upstream in the zone
+// "hostname-upstream", so that it is reported for the host; any other key
+// is used both as the upstream and the zone name. Peers are made up from
+// the state counts of the Stats; Total is ignored, and Backup marks that
+// many of the peers as backup. The caller must close the server.
+func NewFakeServer(upstreams map[string]nginxhealthz.Stats) *httptest.Server {
+	all := make(map[string]upstream, len(upstreams))
+	for key, stats := range upstreams {
+		name, zone := key, key
+		if host, u, ok := strings.Cut(key, "/"); ok {
+			name, zone = u, host+"-"+u
+		}
+		all[name] = newUpstream(zone, stats)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/" {
+			writeJSON(w, []int{1, 2, 3, 4, 5, 6, 7, 8, 9})
+			return
+		}
+		// The path is /api/<version>/http/upstreams[/<name>].
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/"), "/")
+		if len(parts) < 3 || parts[1] != "http" || parts[2] != "upstreams" {
+			http.NotFound(w, r)
+			return
+		}
+		switch len(parts) {
+		case 3:
+			writeJSON(w, all)
+		case 4:
+			u, ok := all[parts[3]]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			writeJSON(w, u)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+type upstream struct {
+	Peers []peer `json:"peers"`
+	Zone  string `json:"zone"`
+}
+
+type peer struct {
+	ID     int    `json:"id"`
+	Server string `json:"server"`
+	Name   string `json:"name"`
+	Backup bool   `json:"backup"`
+	Weight int    `json:"weight"`
+	State  string `json:"state"`
+}
+
+func newUpstream(zone string, s nginxhealthz.Stats) upstream {
+	u := upstream{Zone: zone}
+	for _, st := range []struct {
+		state string
+		n     int
+	}{
+		{"up", s.Up},
+		{"down", s.Down},
+		{"unavail", s.Unavail},
+		{"draining", s.Draining},
+		{"checking", s.Checking},
+		{"unhealthy", s.Unhealthy},
+		{"", s.Unknown},
+	} {
+		for i := 0; i < st.n; i++ {
+			id := len(u.Peers)
+			addr := fmt.Sprintf("10.0.%d.%d:80", id/256, id%256)
+			u.Peers = append(u.Peers, peer{
+				ID:     id,
+				Server: addr,
+				Name:   addr,
+				Backup: id < s.Backup,
+				Weight: 1,
+				State:  st.state,
+			})
+		}
+	}
+	return u
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,82 @@
+package nginxhealthztest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+	"github.com/qba73/nginx-healthz/nginxhealthztest"
+)
+
+func TestNewFakeServer_ServesStatsOfUpstreams(t *testing.T) {
+	t.Parallel()
+
+	ts := nginxhealthztest.NewFakeServer(map[string]nginxhealthz.Stats{
+		"bar.example.org/hg-backend": {Up: 2, Down: 1},
+		"demo-backend":               {Up: 1, Draining: 1, Backup: 1},
+	})
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetStatsFor(context.Background(), "hg-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.Stats{Total: 3, Up: 2, Down: 1}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	got, err = c.GetStatsFor(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = nginxhealthz.Stats{Total: 2, Up: 1, Draining: 1, Backup: 1}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestNewFakeServer_ServesZonesForGetUpstreamsFor(t *testing.T) {
+	t.Parallel()
+
+	ts := nginxhealthztest.NewFakeServer(map[string]nginxhealthz.Stats{
+		"bar.example.org/hg-backend":  {Up: 2},
+		"bar.example.org/lxr-backend": {Up: 1, Down: 1},
+		"foo.example.org/demo":        {Up: 1},
+	})
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := c.GetStatsForHost(context.Background(), "bar.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.Stats{Total: 4, Up: 3, Down: 1}
+	if !cmp.Equal(want, stats) {
+		t.Error(cmp.Diff(want, stats))
+	}
+}
+
+func TestNewFakeServer_RespondsNotFoundForUnknownUpstream(t *testing.T) {
+	t.Parallel()
+
+	ts := nginxhealthztest.NewFakeServer(nil)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStatsFor(context.Background(), "missing"); !errors.Is(err, nginxhealthz.ErrUpstreamNotFound) {
+		t.Errorf("want ErrUpstreamNotFound, got %v", err)
+	}
+}
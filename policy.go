@@ -0,0 +1,54 @@
+package nginxhealthz
+
+// HealthPolicy decides whether the stats of an upstream or host are
+// healthy. The zero value requires all peers to be up. When both
+// thresholds are set, both must be met. Stats without peers, or
+// counting an empty upstream, are never healthy.
+type HealthPolicy struct {
+	// MinUp is the minimum number of peers that must be up.
+	MinUp int
+	// MinUpFraction is the minimum fraction of peers, between 0 and 1,
+	// that must be up.
+	MinUpFraction float64
+}
+
+// Healthy reports whether the stats meet the policy.
+func (p HealthPolicy) Healthy(s Stats) bool {
+	if s.Total == 0 || s.Empty > 0 {
+		return false
+	}
+	if p.MinUp <= 0 && p.MinUpFraction <= 0 {
+		return s.Up == s.Total
+	}
+	if p.MinUp > 0 && s.Up < p.MinUp {
+		return false
+	}
+	if p.MinUpFraction > 0 && s.UpFraction() < p.MinUpFraction {
+		return false
+	}
+	return true
+}
+
+type healthHandlerOption func(*HealthPolicy)
+
+// WithHealthPolicy sets the policy deciding whether the host is healthy.
+func WithHealthPolicy(p HealthPolicy) healthHandlerOption {
+	return func(hp *HealthPolicy) {
+		*hp = p
+	}
+}
+
+// WithMinHealthyPeers makes the host healthy while at least n peers are up.
+func WithMinHealthyPeers(n int) healthHandlerOption {
+	return func(hp *HealthPolicy) {
+		hp.MinUp = n
+	}
+}
+
+// WithMinHealthyFraction makes the host healthy while at least the
+// fraction f, between 0 and 1, of its peers are up.
+func WithMinHealthyFraction(f float64) healthHandlerOption {
+	return func(hp *HealthPolicy) {
+		hp.MinUpFraction = f
+	}
+}
@@ -0,0 +1,129 @@
+package nginxhealthz_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+func TestHealthPolicy_Healthy(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		policy nginxhealthz.HealthPolicy
+		stats  nginxhealthz.Stats
+		want   bool
+	}{
+		{name: "all up by default", stats: nginxhealthz.Stats{Total: 3, Up: 3}, want: true},
+		{name: "one down by default", stats: nginxhealthz.Stats{Total: 3, Up: 2, Down: 1}, want: false},
+		{name: "no peers", policy: nginxhealthz.HealthPolicy{MinUp: 1}, stats: nginxhealthz.Stats{}, want: false},
+		{name: "empty upstream", policy: nginxhealthz.HealthPolicy{MinUp: 1}, stats: nginxhealthz.Stats{Total: 2, Up: 2, Empty: 1}, want: false},
+		{name: "min up met", policy: nginxhealthz.HealthPolicy{MinUp: 2}, stats: nginxhealthz.Stats{Total: 10, Up: 2}, want: true},
+		{name: "min up not met", policy: nginxhealthz.HealthPolicy{MinUp: 3}, stats: nginxhealthz.Stats{Total: 10, Up: 2}, want: false},
+		{name: "fraction met", policy: nginxhealthz.HealthPolicy{MinUpFraction: 0.5}, stats: nginxhealthz.Stats{Total: 10, Up: 5}, want: true},
+		{name: "fraction not met", policy: nginxhealthz.HealthPolicy{MinUpFraction: 0.5}, stats: nginxhealthz.Stats{Total: 10, Up: 4}, want: false},
+		{name: "both must be met", policy: nginxhealthz.HealthPolicy{MinUp: 6, MinUpFraction: 0.5}, stats: nginxhealthz.Stats{Total: 10, Up: 5}, want: false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.policy.Healthy(tc.stats); got != tc.want {
+				t.Errorf("want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestHealthHandler_UsesMinHealthyFraction(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bar.example.org has 3 of 4 peers up.
+	h := nginxhealthz.NewHealthHandler(c, "bar.example.org", nginxhealthz.WithMinHealthyFraction(0.5))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("want status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	h = nginxhealthz.NewHealthHandler(c, "bar.example.org", nginxhealthz.WithMinHealthyPeers(4))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("want status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
+
+func TestServerHealthz_UsesConfiguredHealthPolicy(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := nginxhealthz.NewServerHandler(c, nginxhealthz.ServerConfig{
+		HealthPolicy: nginxhealthz.HealthPolicy{MinUp: 2},
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz?host=bar.example.org", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("want status %d, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestStats_FractionsOfPeersUpAndDown(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		stats    nginxhealthz.Stats
+		up, down float64
+	}{
+		{stats: nginxhealthz.Stats{}, up: 0, down: 0},
+		{stats: nginxhealthz.Stats{Total: 4, Up: 4}, up: 1, down: 0},
+		{stats: nginxhealthz.Stats{Total: 4, Up: 3, Down: 1}, up: 0.75, down: 0.25},
+		{stats: nginxhealthz.Stats{Total: 4, Up: 1, Unavail: 2, Draining: 1}, up: 0.25, down: 0.75},
+	}
+	for _, tt := range tests {
+		if got := tt.stats.UpFraction(); got != tt.up {
+			t.Errorf("%+v: want up fraction %v, got %v", tt.stats, tt.up, got)
+		}
+		if got := tt.stats.DownFraction(); got != tt.down {
+			t.Errorf("%+v: want down fraction %v, got %v", tt.stats, tt.down, got)
+		}
+	}
+}
+
+func TestStats_HealthyComparesDownFractionWithThreshold(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		stats   nginxhealthz.Stats
+		maxDown float64
+		want    bool
+	}{
+		{stats: nginxhealthz.Stats{}, maxDown: 1, want: false},
+		{stats: nginxhealthz.Stats{Total: 4, Up: 4}, maxDown: 0, want: true},
+		{stats: nginxhealthz.Stats{Total: 4, Up: 3, Down: 1}, maxDown: 0, want: false},
+		{stats: nginxhealthz.Stats{Total: 4, Up: 3, Down: 1}, maxDown: 0.25, want: true},
+		{stats: nginxhealthz.Stats{Total: 4, Up: 2, Down: 2}, maxDown: 0.25, want: false},
+		{stats: nginxhealthz.Stats{Total: 4, Up: 4, Empty: 1}, maxDown: 1, want: false},
+	}
+	for _, tt := range tests {
+		if got := tt.stats.Healthy(tt.maxDown); got != tt.want {
+			t.Errorf("%+v with max down %v: want %v, got %v", tt.stats, tt.maxDown, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package nginxhealthz
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EventType identifies the kind of an Event emitted by a Poller.
+type EventType int
+
+const (
+	// StatsCollected is emitted for every host scraped in a poll cycle.
+	StatsCollected EventType = iota
+	// ConfigReloaded is emitted when the NGINX configuration generation
+	// changed since the previous poll cycle.
+	ConfigReloaded
+)
+
+// Event is emitted by a Poller.
+type Event struct {
+	Type EventType
+	Time time.Time
+
+	// Host, Stats and Err are set for StatsCollected events.
+	Host  string
+	Stats Stats
+	Err   error
+
+	// Generation and PreviousGeneration are set for ConfigReloaded events.
+	Generation         int64
+	PreviousGeneration int64
+}
+
+type pollerOption func(*Poller)
+
+// WithReloadDetection makes the poller fetch the NGINX configuration
+// generation once per cycle and emit a ConfigReloaded event when it changes.
+func WithReloadDetection() pollerOption {
+	return func(p *Poller) {
+		p.detectReloads = true
+	}
+}
+
+// withHostStats makes the poller collect the stats of a host with fn
+// instead of Client.GetStatsForHost, so the health server can apply its
+// host cache and concurrency limit to polling.
+func withHostStats(fn func(ctx context.Context, host string) (Stats, error)) pollerOption {
+	return func(p *Poller) {
+		p.hostStats = fn
+	}
+}
+
+// Poller periodically collects stats for a set of hosts.
+type Poller struct {
+	client        *Client
+	interval      time.Duration
+	hosts         []string
+	detectReloads bool
+	hostStats     func(ctx context.Context, host string) (Stats, error)
+
+	mu     sync.RWMutex
+	latest map[string]Event
+}
+
+// NewPoller returns a poller scraping the hosts every interval.
+func NewPoller(c *Client, interval time.Duration, hosts []string, opts ...pollerOption) *Poller {
+	p := &Poller{
+		client:   c,
+		interval: interval,
+		hosts:    hosts,
+		latest:   make(map[string]Event),
+	}
+	p.hostStats = c.GetStatsForHost
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Start polls immediately and then on every interval until ctx is done.
+// The returned channel is closed when polling stops.
+func (p *Poller) Start(ctx context.Context) <-chan Event {
+	events := make(chan Event, len(p.hosts)+1)
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			if !p.poll(ctx, events) {
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events
+}
+
+// poll runs a single cycle. It reports false when ctx is done.
+func (p *Poller) poll(ctx context.Context, events chan<- Event) bool {
+	if p.detectReloads {
+		prev, cur, changed, err := p.client.checkGeneration(ctx)
+		if err == nil && changed {
+			e := Event{
+				Type:               ConfigReloaded,
+				Time:               time.Now(),
+				Generation:         cur,
+				PreviousGeneration: prev,
+			}
+			if !send(ctx, events, e) {
+				return false
+			}
+		}
+	}
+	for _, h := range p.hosts {
+		stats, err := p.hostStats(ctx, h)
+		e := Event{
+			Type:  StatsCollected,
+			Time:  time.Now(),
+			Host:  h,
+			Stats: stats,
+			Err:   err,
+		}
+		if err == nil {
+			p.mu.Lock()
+			p.latest[h] = e
+			p.mu.Unlock()
+		}
+		if !send(ctx, events, e) {
+			return false
+		}
+	}
+	return true
+}
+
+// Latest returns the most recent stats successfully collected for
+// the host and the time they were collected.
+func (p *Poller) Latest(host string) (Stats, time.Time, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	e, ok := p.latest[host]
+	return e.Stats, e.Time, ok
+}
+
+func send(ctx context.Context, events chan<- Event, e Event) bool {
+	select {
+	case events <- e:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// StatsUpdate is emitted by Watch.
+type StatsUpdate struct {
+	Time  time.Time
+	Stats Stats
+	Err   error
+}
+
+// Watch fetches the stats of the upstream immediately and then on every
+// interval, sending them on the returned channel until ctx is done. The
+// channel is closed when watching stops. A failed fetch is sent with Err
+// set and does not stop watching.
+func (c *Client) Watch(ctx context.Context, upstream string, interval time.Duration) (<-chan StatsUpdate, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid watch interval: %v", interval)
+	}
+	if _, err := upstreamPath(c.protocol.String(), upstream); err != nil {
+		return nil, err
+	}
+	updates := make(chan StatsUpdate, 1)
+	go func() {
+		defer close(updates)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			stats, err := c.GetStatsFor(ctx, upstream)
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case updates <- StatsUpdate{Time: time.Now(), Stats: stats, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return updates, nil
+}
@@ -0,0 +1,199 @@
+package nginxhealthz_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+func TestPoller_EmitsStatsForEveryHost(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := nginxhealthz.NewPoller(c, time.Hour, []string{"bar.example.org"})
+	e := <-p.Start(ctx)
+	if e.Type != nginxhealthz.StatsCollected || e.Host != "bar.example.org" {
+		t.Fatalf("want stats event for bar.example.org, got %+v", e)
+	}
+	if e.Err != nil {
+		t.Fatal(e.Err)
+	}
+	want := nginxhealthz.Stats{Total: 4, Up: 3, Down: 1}
+	if e.Stats != want {
+		t.Errorf("want %+v, got %+v", want, e.Stats)
+	}
+	got, _, ok := p.Latest("bar.example.org")
+	if !ok || got != want {
+		t.Errorf("want latest %+v, got %+v (ok=%v)", want, got, ok)
+	}
+}
+
+func TestPoller_EmitsConfigReloadedOnGenerationBump(t *testing.T) {
+	t.Parallel()
+
+	var generation int64 = 1
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/nginx") {
+			// Bump the generation on every other check.
+			g := atomic.AddInt64(&generation, 1) / 2
+			fmt.Fprintf(w, `{"generation": %d}`, g)
+			return
+		}
+		io.WriteString(w, validResponseGetUpstreamsZones)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := nginxhealthz.NewPoller(c, time.Millisecond, nil, nginxhealthz.WithReloadDetection())
+	timeout := time.After(5 * time.Second)
+	events := p.Start(ctx)
+	for {
+		select {
+		case e := <-events:
+			if e.Type != nginxhealthz.ConfigReloaded {
+				continue
+			}
+			if e.PreviousGeneration != 1 || e.Generation != 2 {
+				t.Errorf("want generation change 1 -> 2, got %d -> %d", e.PreviousGeneration, e.Generation)
+			}
+			if c.Generation() != 2 {
+				t.Errorf("want client generation 2, got %d", c.Generation())
+			}
+			return
+		case <-timeout:
+			t.Fatal("timed out waiting for ConfigReloaded event")
+		}
+	}
+}
+
+func TestPoller_ClosesChannelWhenContextIsCancelled(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := nginxhealthz.NewPoller(c, time.Millisecond, []string{"bar.example.org"}).Start(ctx)
+	cancel()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("channel not closed after cancel")
+		}
+	}
+}
+
+func TestWatch_EmitsStatsOnEveryInterval(t *testing.T) {
+	t.Parallel()
+
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		io.WriteString(w, validResponseGetUpstreamAllServersUp)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates, err := c.Watch(ctx, "demo-backend", 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		u := <-updates
+		if u.Err != nil {
+			t.Fatal(u.Err)
+		}
+		if u.Time.IsZero() {
+			t.Error("want update time set")
+		}
+		want := nginxhealthz.Stats{Total: 2, Up: 2}
+		if u.Stats != want {
+			t.Errorf("want %+v, got %+v", want, u.Stats)
+		}
+	}
+	if got := atomic.LoadInt32(&hits); got < 3 {
+		t.Errorf("want at least 3 requests, got %d", got)
+	}
+}
+
+func TestWatch_ClosesChannelWhenContextIsCancelled(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	updates, err := c.Watch(ctx, "hg-backend", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-updates
+	cancel()
+
+	select {
+	case _, ok := <-updates:
+		if ok {
+			t.Fatal("want channel closed, got update")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel was not closed")
+	}
+}
+
+func TestWatch_FailsOnInvalidInterval(t *testing.T) {
+	t.Parallel()
+
+	c, err := nginxhealthz.NewClient("http://localhost:9001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Watch(context.Background(), "demo-backend", 0); err == nil {
+		t.Error("want error, got nil")
+	}
+}
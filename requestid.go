@@ -0,0 +1,46 @@
+package nginxhealthz
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the request ID. The ID is
+// added to log lines and error messages of every NGINX API call made with
+// the context, including concurrent calls made on behalf of a single
+// operation such as GetStatsForHost.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFrom returns the request ID carried by ctx.
+func RequestIDFrom(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok && id != ""
+}
+
+// ensureRequestID returns ctx unchanged if it already carries a request
+// ID, otherwise a copy of ctx with a newly generated one.
+func ensureRequestID(ctx context.Context) context.Context {
+	if _, ok := RequestIDFrom(ctx); ok {
+		return ctx
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ctx
+	}
+	return WithRequestID(ctx, hex.EncodeToString(b))
+}
+
+// loggerFor returns the client logger annotated with the request ID
+// carried by ctx, if any.
+func (c *Client) loggerFor(ctx context.Context) *slog.Logger {
+	if id, ok := RequestIDFrom(ctx); ok {
+		return c.logger.With("request_id", id)
+	}
+	return c.logger
+}
@@ -0,0 +1,69 @@
+package nginxhealthz_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+func TestRequestID_IsIncludedInErrors(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := nginxhealthz.WithRequestID(context.Background(), "probe-42")
+	_, err = c.GetStatsForHost(ctx, "bar.example.org")
+	if err == nil {
+		t.Fatal("want error")
+	}
+	if !strings.Contains(err.Error(), "probe-42") {
+		t.Errorf("want request ID in error, got %q", err)
+	}
+}
+
+func TestRequestID_IsIncludedInLogs(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerSupportingVersion("7", t)
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	c, err := nginxhealthz.NewClient(ts.URL,
+		nginxhealthz.WithVersionFallback(),
+		nginxhealthz.WithLogger(logger),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := nginxhealthz.WithRequestID(context.Background(), "probe-42")
+	if _, err := c.GetStatsFor(ctx, "demo-backend"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "request_id=probe-42") {
+		t.Errorf("want request ID in logs, got %q", buf.String())
+	}
+}
+
+func TestRequestIDFrom_ReturnsFalseWithoutID(t *testing.T) {
+	t.Parallel()
+
+	if _, ok := nginxhealthz.RequestIDFrom(context.Background()); ok {
+		t.Error("want no request ID in empty context")
+	}
+}
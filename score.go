@@ -0,0 +1,85 @@
+package nginxhealthz
+
+import (
+	"context"
+	"errors"
+)
+
+// HealthScoreWeights sets how much each signal contributes to the score
+// computed by HealthScore. Weights are relative to each other.
+type HealthScoreWeights struct {
+	// Up weighs the fraction of peers that are up.
+	Up float64
+	// Errors weighs the fraction of responses that were not 5xx.
+	Errors float64
+	// HealthChecks weighs the fraction of actively checked peers
+	// that passed their last health check.
+	HealthChecks float64
+}
+
+var defaultHealthScoreWeights = HealthScoreWeights{
+	Up:           0.5,
+	Errors:       0.3,
+	HealthChecks: 0.2,
+}
+
+// WithHealthScoreWeights sets the weights used by HealthScore.
+func WithHealthScoreWeights(w HealthScoreWeights) option {
+	return func(c *Client) error {
+		if w.Up < 0 || w.Errors < 0 || w.HealthChecks < 0 {
+			return errors.New("negative health score weight")
+		}
+		if w.Up+w.Errors+w.HealthChecks == 0 {
+			return errors.New("all health score weights are zero")
+		}
+		c.scoreWeights = w
+		return nil
+	}
+}
+
+// HealthScore returns a score from 0 (unhealthy) to 100 (healthy) for the
+// upstream, combining the fraction of up peers, the 5xx error rate and the
+// result of NGINX active health checks.
+func (c *Client) HealthScore(ctx context.Context, upstream string) (float64, error) {
+	res, err := c.getUpstream(ctx, upstream)
+	if err != nil {
+		return 0, err
+	}
+	return healthScore(res, c.scoreWeights, c.isUp)
+}
+
+func healthScore(res responseUpstream, w HealthScoreWeights, isUp func(state string) bool) (float64, error) {
+	if len(res.Peers) < 1 {
+		return 0, ErrNoPeers
+	}
+
+	var up, responses, errs, checked, passed int
+	for _, p := range res.Peers {
+		if isUp(p.State) {
+			up++
+		}
+		responses += p.Responses.Total
+		errs += p.Responses.FiveXx
+		if p.HealthChecks.Checks > 0 {
+			checked++
+			if p.HealthChecks.LastPassed {
+				passed++
+			}
+		}
+	}
+
+	upRatio := float64(up) / float64(len(res.Peers))
+	okRatio := 1.0
+	if responses > 0 {
+		okRatio = 1 - float64(errs)/float64(responses)
+	}
+	// Peers without active health checks give no signal.
+	checkRatio := 1.0
+	if checked > 0 {
+		checkRatio = float64(passed) / float64(checked)
+	}
+
+	sum := w.Up + w.Errors + w.HealthChecks
+	score := (w.Up*upRatio + w.Errors*okRatio + w.HealthChecks*checkRatio) / sum
+	return 100 * score, nil
+}
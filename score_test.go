@@ -0,0 +1,122 @@
+package nginxhealthz_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+const responseUpstreamAllUpServingErrors = `{
+	"peers": [
+		{
+			"server": "10.0.0.1:80",
+			"state": "up",
+			"responses": {"5xx": 50, "total": 100},
+			"health_checks": {"checks": 10, "last_passed": true}
+		},
+		{
+			"server": "10.0.0.2:80",
+			"state": "up",
+			"responses": {"5xx": 50, "total": 100},
+			"health_checks": {"checks": 10, "last_passed": false}
+		}
+	]
+}`
+
+func TestHealthScore_PenalisesErrorsAndFailedChecks(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(responseUpstreamAllUpServingErrors, "/api/8/http/upstreams/demo-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.HealthScore(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 0.5*1 + 0.3*0.5 + 0.2*0.5
+	want := 75.0
+	if math.Abs(want-got) > 1e-9 {
+		t.Errorf("want score %v, got %v", want, got)
+	}
+}
+
+func TestHealthScore_UsesConfiguredWeights(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(responseUpstreamAllUpServingErrors, "/api/8/http/upstreams/demo-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithHealthScoreWeights(nginxhealthz.HealthScoreWeights{Up: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.HealthScore(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 100 {
+		t.Errorf("want score 100, got %v", got)
+	}
+}
+
+func TestHealthScore_CountsDrainingPeersAsUpWhenConfigured(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"peers": [
+			{"server": "10.0.0.1:80", "state": "up"},
+			{"server": "10.0.0.2:80", "state": "draining"}
+		]
+	}`, "/api/8/http/upstreams/demo-backend", t)
+	defer ts.Close()
+
+	weights := nginxhealthz.WithHealthScoreWeights(nginxhealthz.HealthScoreWeights{Up: 1})
+	c, err := nginxhealthz.NewClient(ts.URL, weights, nginxhealthz.WithDrainingCountsAsUp())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.HealthScore(context.Background(), "demo-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 100 {
+		t.Errorf("want score 100, got %v", got)
+	}
+}
+
+func TestHealthScore_FailsWithErrNoPeersOnUpstreamWithoutPeers(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{"peers": []}`, "/api/8/http/upstreams/demo-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.HealthScore(context.Background(), "demo-backend")
+	if !errors.Is(err, nginxhealthz.ErrNoPeers) {
+		t.Errorf("want ErrNoPeers, got %v", err)
+	}
+}
+
+func TestNewClient_FailsOnInvalidHealthScoreWeights(t *testing.T) {
+	t.Parallel()
+
+	for _, w := range []nginxhealthz.HealthScoreWeights{{}, {Up: -1, Errors: 2}} {
+		_, err := nginxhealthz.NewClient("http://localhost:9001", nginxhealthz.WithHealthScoreWeights(w))
+		if err == nil {
+			t.Errorf("want error on weights %+v", w)
+		}
+	}
+}
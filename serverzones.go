@@ -0,0 +1,61 @@
+package nginxhealthz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+)
+
+// ServerZoneStats holds the traffic counters of an HTTP server zone.
+type ServerZoneStats struct {
+	Processing int                 `json:"processing"`
+	Requests   int64               `json:"requests"`
+	Responses  ServerZoneResponses `json:"responses"`
+	Discarded  int64               `json:"discarded"`
+	// Received and Sent are in bytes.
+	Received int64 `json:"received"`
+	Sent     int64 `json:"sent"`
+}
+
+// ServerZoneResponses holds the number of responses
+// sent by a server zone per status class.
+type ServerZoneResponses struct {
+	OneXx   int64            `json:"1xx"`
+	TwoXx   int64            `json:"2xx"`
+	ThreeXx int64            `json:"3xx"`
+	FourXx  int64            `json:"4xx"`
+	FiveXx  int64            `json:"5xx"`
+	Codes   map[string]int64 `json:"codes,omitempty"`
+	Total   int64            `json:"total"`
+}
+
+// ServerZoneNotFoundError is returned by GetServerZoneStats when NGINX
+// has no server zone with the name, including when no server zones are
+// configured at all.
+type ServerZoneNotFoundError struct {
+	Zone string
+}
+
+func (e *ServerZoneNotFoundError) Error() string {
+	return fmt.Sprintf("server zone %s not found", e.Zone)
+}
+
+// GetServerZoneStats returns the traffic stats of the HTTP server zone.
+func (c *Client) GetServerZoneStats(ctx context.Context, zone string) (ServerZoneStats, error) {
+	if zone == "" {
+		return ServerZoneStats{}, errors.New("empty server zone name")
+	}
+	var stats ServerZoneStats
+	err := c.getAPI(ctx, "/http/server_zones/"+url.PathEscape(zone), &stats)
+	if isNotFound(err) {
+		return ServerZoneStats{}, &ServerZoneNotFoundError{Zone: zone}
+	}
+	if err != nil {
+		return ServerZoneStats{}, fmt.Errorf("retrieving server zone %s: %w", zone, err)
+	}
+	// The decoded response may be shared with concurrent callers.
+	stats.Responses.Codes = maps.Clone(stats.Responses.Codes)
+	return stats, nil
+}
@@ -0,0 +1,103 @@
+package nginxhealthz_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+const validResponseServerZone = `{
+  "processing": 2,
+  "requests": 706690,
+  "responses": {
+    "1xx": 0,
+    "2xx": 699482,
+    "3xx": 4522,
+    "4xx": 907,
+    "5xx": 266,
+    "codes": {
+      "200": 699482,
+      "301": 4522,
+      "404": 907,
+      "503": 266
+    },
+    "total": 705177
+  },
+  "discarded": 1513,
+  "received": 172711587,
+  "sent": 19415530115
+}`
+
+func TestGetServerZoneStats_ReturnsTrafficStatsOfZone(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(validResponseServerZone, "/api/8/http/server_zones/hg.nginx.org", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetServerZoneStats(context.Background(), "hg.nginx.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.ServerZoneStats{
+		Processing: 2,
+		Requests:   706690,
+		Responses: nginxhealthz.ServerZoneResponses{
+			TwoXx:   699482,
+			ThreeXx: 4522,
+			FourXx:  907,
+			FiveXx:  266,
+			Codes:   map[string]int64{"200": 699482, "301": 4522, "404": 907, "503": 266},
+			Total:   705177,
+		},
+		Discarded: 1513,
+		Received:  172711587,
+		Sent:      19415530115,
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetServerZoneStats_ReturnsNotFoundErrorForMissingZone(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.GetServerZoneStats(context.Background(), "missing")
+	var nf *nginxhealthz.ServerZoneNotFoundError
+	if !errors.As(err, &nf) {
+		t.Fatalf("want ServerZoneNotFoundError, got %v", err)
+	}
+	if nf.Zone != "missing" {
+		t.Errorf("want zone %q, got %q", "missing", nf.Zone)
+	}
+}
+
+func TestGetServerZoneStats_FailsOnEmptyZoneName(t *testing.T) {
+	t.Parallel()
+
+	c, err := nginxhealthz.NewClient("http://localhost:9001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetServerZoneStats(context.Background(), ""); err == nil {
+		t.Error("want error, got nil")
+	}
+}
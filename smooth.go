@@ -0,0 +1,86 @@
+package nginxhealthz
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SmoothedStats holds exponential moving averages of peer counts.
+type SmoothedStats struct {
+	Total float64
+	Up    float64
+	Down  float64
+}
+
+// UpFraction returns the smoothed fraction of peers that are up,
+// or 0 when the smoothed total is 0.
+func (s SmoothedStats) UpFraction() float64 {
+	if s.Total == 0 {
+		return 0
+	}
+	return s.Up / s.Total
+}
+
+// SmoothedStatsUpdate is emitted by WatchSmoothed. The embedded
+// StatsUpdate holds the raw stats of the fetch.
+type SmoothedStatsUpdate struct {
+	StatsUpdate
+	Smoothed SmoothedStats
+}
+
+// SmoothingAlpha returns the EMA smoothing factor for a window of the
+// given number of updates, 2/(window+1).
+func SmoothingAlpha(window int) float64 {
+	if window < 1 {
+		window = 1
+	}
+	return 2 / float64(window+1)
+}
+
+// WatchSmoothed works like Watch and also smooths the peer counts with
+// an exponential moving average, so a single failed probe or an NGINX
+// reload does not flip alerts. Each update weighs the new counts by
+// alpha, between 0 and 1, and the previous average by 1-alpha; use
+// SmoothingAlpha to derive alpha from a window. The first successful
+// fetch seeds the average. Failed fetches are sent with Err set and the
+// last average, which they do not change.
+func (c *Client) WatchSmoothed(ctx context.Context, upstream string, interval time.Duration, alpha float64) (<-chan SmoothedStatsUpdate, error) {
+	if alpha <= 0 || alpha > 1 {
+		return nil, fmt.Errorf("invalid smoothing alpha: %v", alpha)
+	}
+	raw, err := c.Watch(ctx, upstream, interval)
+	if err != nil {
+		return nil, err
+	}
+	updates := make(chan SmoothedStatsUpdate, 1)
+	go func() {
+		defer close(updates)
+		var avg SmoothedStats
+		seeded := false
+		for u := range raw {
+			if u.Err == nil {
+				avg = smooth(avg, u.Stats, alpha, seeded)
+				seeded = true
+			}
+			select {
+			case updates <- SmoothedStatsUpdate{StatsUpdate: u, Smoothed: avg}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return updates, nil
+}
+
+func smooth(avg SmoothedStats, s Stats, alpha float64, seeded bool) SmoothedStats {
+	cur := SmoothedStats{Total: float64(s.Total), Up: float64(s.Up), Down: float64(s.Down)}
+	if !seeded {
+		return cur
+	}
+	return SmoothedStats{
+		Total: alpha*cur.Total + (1-alpha)*avg.Total,
+		Up:    alpha*cur.Up + (1-alpha)*avg.Up,
+		Down:  alpha*cur.Down + (1-alpha)*avg.Down,
+	}
+}
@@ -0,0 +1,121 @@
+package nginxhealthz_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+func TestWatchSmoothed_DampensPeerGoingDown(t *testing.T) {
+	t.Parallel()
+
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			io.WriteString(w, validResponseGetUpstreamAllServersUp)
+			return
+		}
+		io.WriteString(w, validResponseUpstreamHGbackend)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates, err := c.WatchSmoothed(ctx, "demo-backend", 10*time.Millisecond, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []nginxhealthz.SmoothedStats{
+		{Total: 2, Up: 2, Down: 0},
+		{Total: 2, Up: 1.5, Down: 0.5},
+		{Total: 2, Up: 1.25, Down: 0.75},
+	}
+	for i, w := range want {
+		u := <-updates
+		if u.Err != nil {
+			t.Fatal(u.Err)
+		}
+		if u.Smoothed != w {
+			t.Errorf("update %d: want smoothed %+v, got %+v", i, w, u.Smoothed)
+		}
+	}
+}
+
+func TestWatchSmoothed_KeepsAverageOnFailedFetch(t *testing.T) {
+	t.Parallel()
+
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			io.WriteString(w, validResponseGetUpstreamAllServersUp)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates, err := c.WatchSmoothed(ctx, "demo-backend", 10*time.Millisecond, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-updates
+	u := <-updates
+	if u.Err == nil {
+		t.Fatal("want fetch error, got nil")
+	}
+	want := nginxhealthz.SmoothedStats{Total: 2, Up: 2}
+	if u.Smoothed != want {
+		t.Errorf("want smoothed %+v, got %+v", want, u.Smoothed)
+	}
+}
+
+func TestWatchSmoothed_FailsOnInvalidAlpha(t *testing.T) {
+	t.Parallel()
+
+	c, err := nginxhealthz.NewClient("http://localhost:9001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, alpha := range []float64{0, -0.5, 1.5} {
+		if _, err := c.WatchSmoothed(context.Background(), "demo-backend", time.Second, alpha); err == nil {
+			t.Errorf("alpha %v: want error, got nil", alpha)
+		}
+	}
+}
+
+func TestSmoothingAlpha_DerivesAlphaFromWindow(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		window int
+		want   float64
+	}{
+		{window: 1, want: 1},
+		{window: 3, want: 0.5},
+		{window: 9, want: 0.2},
+		{window: 0, want: 1},
+	}
+	for _, tt := range tests {
+		if got := nginxhealthz.SmoothingAlpha(tt.window); got != tt.want {
+			t.Errorf("window %d: want %v, got %v", tt.window, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package nginxhealthz
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WithSnapshotFile makes the client persist the latest stats collected by
+// GetStatsForHost to the file at path, and reload them when the client is
+// created. This lets a restarted health server report the last known stats
+// through LastKnownStatsForHost until fresh data is collected. The file is
+// replaced atomically when the stats of a host change, and at most every
+// snapshotRefresh otherwise; an unreadable or corrupt file is ignored.
+func WithSnapshotFile(path string) option {
+	return func(c *Client) error {
+		if path == "" {
+			return errors.New("empty snapshot file path")
+		}
+		c.snapshotPath = path
+		return nil
+	}
+}
+
+type snapshotFile struct {
+	SavedAt time.Time                `json:"saved_at"`
+	Hosts   map[string]snapshotEntry `json:"hosts"`
+}
+
+type snapshotEntry struct {
+	Stats       Stats     `json:"stats"`
+	CollectedAt time.Time `json:"collected_at"`
+	// loaded is set for entries read from the snapshot
+	// file and not replaced by collected stats since.
+	loaded bool
+}
+
+// snapshotRefresh is how often the snapshot file is rewritten while
+// the stats stay the same, so the collection times it holds stay recent.
+const snapshotRefresh = time.Minute
+
+// LastKnownStatsForHost returns the most recent stats successfully
+// collected for the host, including stats loaded from the snapshot
+// file, and the time they were collected.
+func (c *Client) LastKnownStatsForHost(host string) (Stats, time.Time, bool) {
+	c.snapshotMu.Lock()
+	defer c.snapshotMu.Unlock()
+	e, ok := c.snapshot[host]
+	return e.Stats, e.CollectedAt, ok
+}
+
+// warmUpStatsForHost returns the stats of the host loaded from the
+// snapshot file, if no stats were collected since and they are at most
+// maxAge old. They stand in for the host while a restarted server warms up.
+func (c *Client) warmUpStatsForHost(host string, maxAge time.Duration) (Stats, time.Time, bool) {
+	c.snapshotMu.Lock()
+	defer c.snapshotMu.Unlock()
+	e, ok := c.snapshot[host]
+	if !ok || !e.loaded || c.clock.Now().Sub(e.CollectedAt) > maxAge {
+		return Stats{}, time.Time{}, false
+	}
+	return e.Stats, e.CollectedAt, true
+}
+
+func (c *Client) loadSnapshot() {
+	data, err := os.ReadFile(c.snapshotPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		c.logger.Warn("reading snapshot file", "path", c.snapshotPath, "error", err)
+		return
+	}
+	var sf snapshotFile
+	if err := json.Unmarshal(data, &sf); err != nil {
+		c.logger.Warn("ignoring corrupt snapshot file", "path", c.snapshotPath, "error", err)
+		return
+	}
+	c.snapshotMu.Lock()
+	defer c.snapshotMu.Unlock()
+	for host, e := range sf.Hosts {
+		e.loaded = true
+		c.snapshot[host] = e
+	}
+}
+
+// recordSnapshot stores the stats collected for the host and, when a
+// snapshot file is set, persists the snapshot if the stats changed or
+// the file is due for a refresh. The file is written outside snapshotMu,
+// so concurrent callers with unchanged stats never wait for it.
+func (c *Client) recordSnapshot(host string, stats Stats) {
+	now := c.clock.Now()
+	c.snapshotMu.Lock()
+	prev, seen := c.snapshot[host]
+	c.snapshot[host] = snapshotEntry{Stats: stats, CollectedAt: now}
+	if c.snapshotPath == "" || (seen && prev.Stats == stats && now.Sub(c.snapshotSavedAt) < snapshotRefresh) {
+		c.snapshotMu.Unlock()
+		return
+	}
+	c.snapshotSavedAt = now
+	c.snapshotSeq++
+	seq := c.snapshotSeq
+	sf := snapshotFile{SavedAt: now, Hosts: maps.Clone(c.snapshot)}
+	c.snapshotMu.Unlock()
+
+	// Writes are serialised and a write older than
+	// the last one written is dropped.
+	c.snapshotWriteMu.Lock()
+	defer c.snapshotWriteMu.Unlock()
+	if seq <= c.snapshotWritten {
+		return
+	}
+	if err := writeFileAtomic(c.snapshotPath, sf); err != nil {
+		c.logger.Warn("writing snapshot file", "path", c.snapshotPath, "error", err)
+		return
+	}
+	c.snapshotWritten = seq
+}
+
+// writeFileAtomic writes v as JSON to a temporary file in the same
+// directory as path and renames it over path, so readers never
+// observe a partially written file.
+func writeFileAtomic(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encoding snapshot: %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
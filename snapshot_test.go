@@ -0,0 +1,206 @@
+package nginxhealthz_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+	"github.com/qba73/nginx-healthz/nginxhealthztest"
+)
+
+func TestSnapshotFile_PersistsStatsAcrossClients(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+
+	nginx := newTestNGINX(t)
+	defer nginx.Close()
+
+	c, err := nginxhealthz.NewClient(nginx.URL, nginxhealthz.WithSnapshotFile(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := c.GetStatsForHost(context.Background(), "bar.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, err := nginxhealthz.NewClient("http://localhost:9001", nginxhealthz.WithSnapshotFile(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, at, ok := restarted.LastKnownStatsForHost("bar.example.org")
+	if !ok {
+		t.Fatal("want stats loaded from snapshot")
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	if at.IsZero() || time.Since(at) > time.Minute {
+		t.Errorf("want recent collection time, got %v", at)
+	}
+}
+
+func TestSnapshotFile_ServesStaleStatsWhenAPIFails(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	collected := time.Date(2022, 10, 17, 20, 38, 40, 0, time.UTC)
+	data := `{"saved_at": "2022-10-17T20:38:40Z", "hosts": {"bar.example.org": {
+		"stats": {"total": 2, "up": 2, "down": 0},
+		"collected_at": "2022-10-17T20:38:40Z"
+	}}}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	nginx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer nginx.Close()
+
+	clock := &fakeClock{now: collected.Add(time.Minute)}
+	c, err := nginxhealthz.NewClient(nginx.URL, nginxhealthz.WithSnapshotFile(path), nginxhealthz.WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	nginxhealthz.NewServerHandler(c, nginxhealthz.ServerConfig{}).
+		ServeHTTP(rec, newJSONRequest("/healthz?host=bar.example.org"))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("want status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var got map[string]struct {
+		Stats       nginxhealthz.Stats `json:"stats"`
+		Stale       bool               `json:"stale"`
+		CollectedAt time.Time          `json:"collected_at"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	st := got["bar.example.org"]
+	if !st.Stale || !st.CollectedAt.Equal(collected) {
+		t.Errorf("want stale stats collected at %v, got %+v", collected, st)
+	}
+}
+
+func TestSnapshotFile_IgnoresCorruptFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := os.WriteFile(path, []byte(`{"hosts": {`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := nginxhealthz.NewClient("http://localhost:9001", nginxhealthz.WithSnapshotFile(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := c.LastKnownStatsForHost("bar.example.org"); ok {
+		t.Error("want no stats from corrupt snapshot")
+	}
+}
+
+func TestSnapshotFile_ReportsUnavailableWhenSnapshotIsTooOld(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	collected := time.Date(2022, 10, 17, 20, 38, 40, 0, time.UTC)
+	data := `{"saved_at": "2022-10-17T20:38:40Z", "hosts": {"bar.example.org": {
+		"stats": {"total": 2, "up": 2, "down": 0},
+		"collected_at": "2022-10-17T20:38:40Z"
+	}}}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	nginx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer nginx.Close()
+
+	clock := &fakeClock{now: collected.Add(time.Hour)}
+	c, err := nginxhealthz.NewClient(nginx.URL, nginxhealthz.WithSnapshotFile(path), nginxhealthz.WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	nginxhealthz.NewServerHandler(c, nginxhealthz.ServerConfig{SnapshotMaxAge: 10 * time.Minute}).
+		ServeHTTP(rec, newJSONRequest("/healthz?host=bar.example.org"))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("want status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
+
+func TestServerHealthz_ReportsUnavailableWhenAPIFailsAfterSuccessfulProbe(t *testing.T) {
+	t.Parallel()
+
+	var failing atomic.Bool
+	healthy := nginxhealthztest.NewFakeServer(map[string]nginxhealthz.Stats{
+		"bar.example.org/hg-backend": {Up: 2},
+	})
+	defer healthy.Close()
+	nginx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		healthy.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer nginx.Close()
+
+	c, err := nginxhealthz.NewClient(nginx.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := nginxhealthz.NewServerHandler(c, nginxhealthz.ServerConfig{})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, newJSONRequest("/healthz?host=bar.example.org"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want status %d, got %d", http.StatusOK, rec.Code)
+	}
+	failing.Store(true)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, newJSONRequest("/healthz?host=bar.example.org"))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("want status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
+
+func TestSnapshotFile_IsRewrittenOnlyWhenStatsChange(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	nginx := newTestNGINX(t)
+	defer nginx.Close()
+
+	c, err := nginxhealthz.NewClient(nginx.URL, nginxhealthz.WithSnapshotFile(path), nginxhealthz.WithClock(newFakeClock()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := c.GetStatsForHost(ctx, "bar.example.org"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStatsForHost(ctx, "bar.example.org"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("want snapshot not rewritten for unchanged stats, got %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package nginxhealthz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Throughput holds the traffic counters of an upstream, summed across its
+// peers. The counters are int64 so that byte counts of busy upstreams
+// do not overflow on any platform.
+type Throughput struct {
+	Requests int64 `json:"requests"`
+	// Sent and Received are in bytes.
+	Sent     int64 `json:"sent"`
+	Received int64 `json:"received"`
+}
+
+func (t Throughput) add(o Throughput) Throughput {
+	return Throughput{
+		Requests: t.Requests + o.Requests,
+		Sent:     t.Sent + o.Sent,
+		Received: t.Received + o.Received,
+	}
+}
+
+// GetThroughputFor returns the number of requests and bytes sent to and
+// received from the peers of the upstream since NGINX started counting.
+func (c *Client) GetThroughputFor(ctx context.Context, upstream string) (Throughput, error) {
+	res, err := c.getUpstream(ctx, upstream)
+	if err != nil {
+		return Throughput{}, err
+	}
+	var t Throughput
+	for _, p := range res.Peers {
+		t = t.add(Throughput{Requests: int64(p.Requests), Sent: p.Sent, Received: p.Received})
+	}
+	return t, nil
+}
+
+// GetThroughputForHost returns the throughput summed across the upstreams
+// of the host. The sum leaves out upstreams that fail, as
+// GetDownPeersForHost does.
+func (c *Client) GetThroughputForHost(ctx context.Context, hostname string) (Throughput, error) {
+	upstreams, err := c.hostUpstreams(ctx, hostname)
+	if err != nil {
+		return Throughput{}, fmt.Errorf("getting throughput for host %s: %w", hostname, err)
+	}
+	ux, ok := upstreams[hostname]
+	if !ok {
+		return Throughput{}, fmt.Errorf("no stat data for host %s", hostname)
+	}
+
+	throughputs, errs := fanOut(ctx, c, ux, false, c.GetThroughputFor)
+	var total Throughput
+	var failed []error
+	for i, u := range ux {
+		if errs[i] != nil {
+			failed = append(failed, fmt.Errorf("upstream %s: %w", u, errs[i]))
+			continue
+		}
+		total = total.add(throughputs[i])
+	}
+	return total, errors.Join(failed...)
+}
@@ -0,0 +1,96 @@
+package nginxhealthz_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+func TestGetThroughputFor_SumsCountersAcrossPeers(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(validResponseUpstreamLXRbackend, "/api/8/http/upstreams/lxr-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetThroughputFor(context.Background(), "lxr-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.Throughput{
+		Requests: 41612031,
+		Sent:     17635811826,
+		Received: 35466146856,
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetThroughputForHost_SumsCountersAcrossUpstreams(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetThroughputForHost(context.Background(), "bar.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nginxhealthz.Throughput{
+		Requests: 2 * 41612031,
+		Sent:     2 * 17635811826,
+		Received: 2 * 35466146856,
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestGetThroughputForHost_FailsForUnknownHost(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetThroughputForHost(context.Background(), "unknown.example.org"); err == nil {
+		t.Error("want error, got nil")
+	}
+}
+
+func TestGetThroughputForHost_ReadsStreamUpstreamsWithStreamProtocol(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestStreamNGINX(t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithProtocol(nginxhealthz.ProtocolStream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := c.GetThroughputFor(context.Background(), "mysql-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetThroughputForHost(context.Background(), "bar.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want || got.Requests == 0 {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+}
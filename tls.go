@@ -0,0 +1,117 @@
+package nginxhealthz
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+var errTransportWithHTTPClient = errors.New("TLS and connection pool options cannot be combined with WithHTTPClient")
+
+// WithClientCert makes the client authenticate to the NGINX API with the
+// certificate and key in the PEM files, and verify the API server against
+// the CA certificates in caFile. An empty caFile means the system roots.
+// It configures the default HTTP client, so it cannot be combined with
+// WithHTTPClient.
+func WithClientCert(certFile, keyFile, caFile string) option {
+	return func(c *Client) error {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("loading client certificate: %w", err)
+		}
+		cfg := c.tlsClientConfig()
+		cfg.Certificates = []tls.Certificate{cert}
+		if caFile == "" {
+			return nil
+		}
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("reading CA certificates: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no CA certificates in %s", caFile)
+		}
+		cfg.RootCAs = pool
+		return nil
+	}
+}
+
+// WithInsecureSkipVerify makes the client accept any certificate presented
+// by the NGINX API, such as a self-signed one. It disables protection
+// against man-in-the-middle attacks and is meant for testing and staging
+// environments only, never for production. It configures the default
+// HTTP client, so it cannot be combined with WithHTTPClient.
+func WithInsecureSkipVerify() option {
+	return func(c *Client) error {
+		c.tlsClientConfig().InsecureSkipVerify = true
+		return nil
+	}
+}
+
+// WithMaxIdleConnsPerHost sets how many idle connections to the NGINX API
+// the client keeps open for reuse, so that repeated probes do not pay for
+// a new TCP and TLS handshake. The default is the limit set with
+// WithMaxConcurrency, enough for every concurrent request of a call to
+// reuse a connection. It configures the default HTTP client, so it cannot
+// be combined with WithHTTPClient.
+func WithMaxIdleConnsPerHost(n int) option {
+	return func(c *Client) error {
+		if n <= 0 {
+			return fmt.Errorf("invalid max idle connections per host: %d", n)
+		}
+		c.maxIdleConnsPerHost = n
+		return nil
+	}
+}
+
+// WithIdleConnTimeout sets how long an idle connection to the NGINX API
+// is kept open. The default is 90 seconds, as for http.DefaultTransport.
+// It configures the default HTTP client, so it cannot be combined with
+// WithHTTPClient.
+func WithIdleConnTimeout(d time.Duration) option {
+	return func(c *Client) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid idle connection timeout: %v", d)
+		}
+		c.idleConnTimeout = d
+		return nil
+	}
+}
+
+// tlsClientConfig returns the TLS config of the default HTTP
+// client, creating it on first use.
+func (c *Client) tlsClientConfig() *tls.Config {
+	if c.tlsConfig == nil {
+		c.tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return c.tlsConfig
+}
+
+// transportConfigured reports whether options
+// configuring the default transport were used.
+func (c *Client) transportConfigured() bool {
+	return c.tlsConfig != nil || c.maxIdleConnsPerHost != 0 || c.idleConnTimeout != 0
+}
+
+// defaultHTTPClient builds the HTTP client used when none is
+// provided with WithHTTPClient.
+func (c *Client) defaultHTTPClient() *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if c.tlsConfig != nil {
+		t.TLSClientConfig = c.tlsConfig
+	}
+	t.MaxIdleConnsPerHost = c.maxConcurrency
+	if c.maxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = c.maxIdleConnsPerHost
+	}
+	t.MaxIdleConns = max(t.MaxIdleConns, t.MaxIdleConnsPerHost)
+	if c.idleConnTimeout > 0 {
+		t.IdleConnTimeout = c.idleConnTimeout
+	}
+	return &http.Client{Timeout: c.timeout, Transport: t}
+}
@@ -0,0 +1,265 @@
+package nginxhealthz_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+// writeClientCert generates a self-signed client certificate and
+// writes it and its key as PEM files to dir.
+func writeClientCert(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "nginx-healthz"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, "client.pem")
+	keyFile = filepath.Join(dir, "client-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile, cert
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWithClientCert_AuthenticatesToAPI(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	certFile, keyFile, clientCert := writeClientCert(t, dir)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			t.Error("want client certificate")
+		}
+		io.WriteString(w, validResponseGetUpstreamAllServersUp)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	ts.StartTLS()
+	defer ts.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", ts.Certificate().Raw)
+
+	c, err := nginxhealthz.NewClient(ts.URL,
+		nginxhealthz.WithClientCert(certFile, keyFile, caFile),
+		nginxhealthz.WithTimeout(5*time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStatsFor(context.Background(), "demo-backend"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWithClientCert_FailsOnMissingFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	_, err := nginxhealthz.NewClient("https://localhost:9001",
+		nginxhealthz.WithClientCert(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), ""),
+	)
+	if err == nil {
+		t.Fatal("want error")
+	}
+}
+
+func TestWithClientCert_FailsWithCustomHTTPClient(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, _ := writeClientCert(t, t.TempDir())
+	_, err := nginxhealthz.NewClient("https://localhost:9001",
+		nginxhealthz.WithHTTPClient(&http.Client{}),
+		nginxhealthz.WithClientCert(certFile, keyFile, ""),
+	)
+	if err == nil {
+		t.Fatal("want error")
+	}
+}
+
+func TestWithInsecureSkipVerify_AcceptsSelfSignedCertificate(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, validResponseGetUpstreamAllServersUp)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL,
+		nginxhealthz.WithInsecureSkipVerify(),
+		nginxhealthz.WithTimeout(5*time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStatsFor(context.Background(), "demo-backend"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetStatsFor_RejectsSelfSignedCertificateByDefault(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, validResponseGetUpstreamAllServersUp)
+	}))
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStatsFor(context.Background(), "demo-backend"); err == nil {
+		t.Fatal("want certificate verification error")
+	}
+}
+
+func TestWithInsecureSkipVerify_FailsWithCustomHTTPClient(t *testing.T) {
+	t.Parallel()
+
+	h := &http.Client{}
+	_, err := nginxhealthz.NewClient("https://localhost:9001",
+		nginxhealthz.WithHTTPClient(h),
+		nginxhealthz.WithInsecureSkipVerify(),
+	)
+	if err == nil {
+		t.Fatal("want error")
+	}
+	if h.Transport != nil {
+		t.Error("custom HTTP client was modified")
+	}
+}
+
+// newConnCountingServer returns a server answering with the
+// upstream JSON and counting the connections made to it.
+func newConnCountingServer(conns *int32) *httptest.Server {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, validResponseGetUpstreamAllServersUp+"\n")
+	}))
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(conns, 1)
+		}
+	}
+	ts.Start()
+	return ts
+}
+
+func TestWithMaxIdleConnsPerHost_ReusesConnectionsAcrossCalls(t *testing.T) {
+	t.Parallel()
+
+	var conns int32
+	ts := newConnCountingServer(&conns)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL,
+		nginxhealthz.WithMaxConcurrency(4),
+		nginxhealthz.WithMaxIdleConnsPerHost(4),
+		nginxhealthz.WithIdleConnTimeout(time.Minute),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstreams := []string{"a", "b", "c", "d"}
+	for i := 0; i < 5; i++ {
+		if _, err := c.GetStatsForUpstreams(context.Background(), upstreams); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := atomic.LoadInt32(&conns); got > 4 {
+		t.Errorf("want at most 4 connections, got %d", got)
+	}
+}
+
+func TestGetStatsFor_ReusesConnectionByDefault(t *testing.T) {
+	t.Parallel()
+
+	var conns int32
+	ts := newConnCountingServer(&conns)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := c.GetStatsFor(context.Background(), "demo-backend"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := atomic.LoadInt32(&conns); got != 1 {
+		t.Errorf("want 1 connection, got %d", got)
+	}
+}
+
+func TestNewClient_FailsOnConnectionPoolOptionsWithCustomHTTPClient(t *testing.T) {
+	t.Parallel()
+
+	h := nginxhealthz.WithHTTPClient(&http.Client{})
+	if _, err := nginxhealthz.NewClient("http://localhost:9001", h, nginxhealthz.WithMaxIdleConnsPerHost(4)); err == nil {
+		t.Error("want error for max idle connections, got nil")
+	}
+	if _, err := nginxhealthz.NewClient("http://localhost:9001", h, nginxhealthz.WithIdleConnTimeout(time.Minute)); err == nil {
+		t.Error("want error for idle connection timeout, got nil")
+	}
+}
+
+func TestNewClient_FailsOnInvalidConnectionPoolOptions(t *testing.T) {
+	t.Parallel()
+
+	if _, err := nginxhealthz.NewClient("http://localhost:9001", nginxhealthz.WithMaxIdleConnsPerHost(0)); err == nil {
+		t.Error("want error for zero idle connections, got nil")
+	}
+	if _, err := nginxhealthz.NewClient("http://localhost:9001", nginxhealthz.WithIdleConnTimeout(-time.Second)); err == nil {
+		t.Error("want error for negative timeout, got nil")
+	}
+}
@@ -0,0 +1,62 @@
+package nginxhealthz
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const tracerName = "github.com/qba73/nginx-healthz"
+
+// WithTracerProvider makes the client record an OpenTelemetry span for
+// every request to the NGINX API, and a parent span for host-wide calls
+// such as GetStatsForHost. Without it the client uses a no-op tracer.
+func WithTracerProvider(tp trace.TracerProvider) option {
+	return func(c *Client) error {
+		if tp == nil {
+			return errors.New("nil tracer provider")
+		}
+		c.tracer = tp.Tracer(tracerName)
+		return nil
+	}
+}
+
+func defaultTracer() trace.Tracer {
+	return noop.NewTracerProvider().Tracer(tracerName)
+}
+
+type upstreamKey struct{}
+
+// withUpstream records the upstream a request is made for,
+// so its span can be attributed to it.
+func withUpstream(ctx context.Context, upstream string) context.Context {
+	return context.WithValue(ctx, upstreamKey{}, upstream)
+}
+
+// startRequestSpan starts the span of a single request to the NGINX API.
+func (c *Client) startRequestSpan(ctx context.Context, method, url string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		attribute.String("http.request.method", method),
+		attribute.String("url.full", url),
+	}
+	if u, ok := ctx.Value(upstreamKey{}).(string); ok {
+		attrs = append(attrs, attribute.String("nginx.upstream", u))
+	}
+	return c.tracer.Start(ctx, "nginx api "+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// endSpan records the error, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,81 @@
+package nginxhealthz_test
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+func hasAttribute(attrs []attribute.KeyValue, want attribute.KeyValue) bool {
+	for _, a := range attrs {
+		if a == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWithTracerProvider_RecordsSpansForHostRequests(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestNGINX(t)
+	defer ts.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	c, err := nginxhealthz.NewClient(ts.URL, nginxhealthz.WithTracerProvider(tp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStatsForHost(context.Background(), "bar.example.org"); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := recorder.Ended()
+	var parent sdktrace.ReadOnlySpan
+	for _, s := range spans {
+		if s.Name() == "GetStatsForHost" {
+			parent = s
+		}
+	}
+	if parent == nil {
+		t.Fatal("want GetStatsForHost span")
+	}
+	if !hasAttribute(parent.Attributes(), attribute.String("nginx.host", "bar.example.org")) {
+		t.Errorf("want host attribute, got %v", parent.Attributes())
+	}
+
+	var upstreamSpans int
+	for _, s := range spans {
+		if s.Name() == "GetStatsForHost" {
+			continue
+		}
+		if s.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("span %q is not a child of GetStatsForHost", s.Name())
+		}
+		if !hasAttribute(s.Attributes(), attribute.Int("http.response.status_code", 200)) {
+			t.Errorf("want status code attribute, got %v", s.Attributes())
+		}
+		for _, u := range []string{"hg-backend", "lxr-backend"} {
+			if hasAttribute(s.Attributes(), attribute.String("nginx.upstream", u)) {
+				upstreamSpans++
+			}
+		}
+	}
+	if upstreamSpans != 2 {
+		t.Errorf("want 2 upstream spans, got %d", upstreamSpans)
+	}
+}
+
+func TestNewClient_FailsOnNilTracerProvider(t *testing.T) {
+	t.Parallel()
+
+	if _, err := nginxhealthz.NewClient("http://localhost:9001", nginxhealthz.WithTracerProvider(nil)); err == nil {
+		t.Fatal("want error")
+	}
+}
@@ -0,0 +1,125 @@
+package nginxhealthz
+
+import (
+	"sync"
+	"time"
+)
+
+// Host-level states reported by StateTracker.ObserveHost.
+const (
+	HostHealthy   = "healthy"
+	HostUnhealthy = "unhealthy"
+)
+
+// Transition records a change of the health state of a peer or a host.
+// Peer and Upstream are empty for host-level transitions.
+type Transition struct {
+	Host     string    `json:"host"`
+	Upstream string    `json:"upstream,omitempty"`
+	Peer     string    `json:"peer,omitempty"`
+	OldState string    `json:"old_state"`
+	NewState string    `json:"new_state"`
+	Time     time.Time `json:"time"`
+}
+
+// trackerOption configures a StateTracker.
+type trackerOption func(*StateTracker)
+
+// WithTrackerPeerIdentity sets the field identifying peers in the
+// transitions, as WithPeerIdentity does for the client. Peers are
+// identified by their address by default.
+func WithTrackerPeerIdentity(id PeerIdentity) trackerOption {
+	return func(t *StateTracker) {
+		t.peerIdentity = id
+	}
+}
+
+// WithTrackerHealthPolicy sets the policy deciding whether an observed
+// host is healthy, as HealthPolicy does for the health server. The zero
+// policy requires all peers to be up.
+func WithTrackerHealthPolicy(p HealthPolicy) trackerOption {
+	return func(t *StateTracker) {
+		t.policy = p
+	}
+}
+
+type peerKey struct {
+	host, upstream, peer string
+}
+
+// StateTracker remembers the last observed state of peers and hosts and
+// reports transitions between observations. The first observation of a
+// peer or host is not a transition. It is safe for concurrent use.
+type StateTracker struct {
+	mu           sync.Mutex
+	peers        map[peerKey]string
+	hosts        map[string]string
+	peerIdentity PeerIdentity
+	policy       HealthPolicy
+}
+
+// NewStateTracker returns an empty StateTracker.
+func NewStateTracker(opts ...trackerOption) *StateTracker {
+	t := &StateTracker{
+		peers: make(map[peerKey]string),
+		hosts: make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// ObservePeers records the states of the peers of the upstream
+// and returns the peers whose state changed.
+func (t *StateTracker) ObservePeers(host, upstream string, peers []Peer) []Transition {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	var transitions []Transition
+	for _, p := range peers {
+		id := t.peerIdentity.of(p.Server, p.Name)
+		k := peerKey{host: host, upstream: upstream, peer: id}
+		old, seen := t.peers[k]
+		t.peers[k] = p.State
+		if !seen || old == p.State {
+			continue
+		}
+		transitions = append(transitions, Transition{
+			Host:     host,
+			Upstream: upstream,
+			Peer:     id,
+			OldState: old,
+			NewState: p.State,
+			Time:     now,
+		})
+	}
+	return transitions
+}
+
+// ObserveHost records whether the host is healthy according to the
+// health policy of the tracker, by default when all its peers are up
+// and none of its upstreams is empty, and returns a transition if that
+// changed.
+func (t *StateTracker) ObserveHost(host string, stats Stats) []Transition {
+	state := HostUnhealthy
+	if t.policy.Healthy(stats) {
+		state = HostHealthy
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	old, seen := t.hosts[host]
+	t.hosts[host] = state
+	if !seen || old == state {
+		return nil
+	}
+	return []Transition{{
+		Host:     host,
+		OldState: old,
+		NewState: state,
+		Time:     time.Now(),
+	}}
+}
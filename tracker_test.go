@@ -0,0 +1,101 @@
+package nginxhealthz_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+func TestStateTracker_ReportsPeerTransitions(t *testing.T) {
+	t.Parallel()
+
+	st := nginxhealthz.NewStateTracker()
+	first := st.ObservePeers("bar.example.org", "hg-backend", []nginxhealthz.Peer{
+		{Server: "10.0.0.1:80", State: "up"},
+		{Server: "10.0.0.2:80", State: "up"},
+	})
+	if len(first) != 0 {
+		t.Errorf("want no transitions on first observation, got %+v", first)
+	}
+
+	got := st.ObservePeers("bar.example.org", "hg-backend", []nginxhealthz.Peer{
+		{Server: "10.0.0.1:80", State: "up"},
+		{Server: "10.0.0.2:80", State: "down"},
+	})
+	want := []nginxhealthz.Transition{{
+		Host:     "bar.example.org",
+		Upstream: "hg-backend",
+		Peer:     "10.0.0.2:80",
+		OldState: "up",
+		NewState: "down",
+	}}
+	if !cmp.Equal(want, got, cmpopts.IgnoreFields(nginxhealthz.Transition{}, "Time")) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestStateTracker_IdentifiesPeersAsConfigured(t *testing.T) {
+	t.Parallel()
+
+	st := nginxhealthz.NewStateTracker(nginxhealthz.WithTrackerPeerIdentity(nginxhealthz.PeerIdentityName))
+	st.ObservePeers("bar.example.org", "hg-backend", []nginxhealthz.Peer{
+		{Server: "10.0.0.1:80", Name: "app.example.org:80", State: "up"},
+	})
+	// The name resolved to a new address, but it is the same peer.
+	got := st.ObservePeers("bar.example.org", "hg-backend", []nginxhealthz.Peer{
+		{Server: "10.0.0.9:80", Name: "app.example.org:80", State: "down"},
+	})
+	want := []nginxhealthz.Transition{{
+		Host:     "bar.example.org",
+		Upstream: "hg-backend",
+		Peer:     "app.example.org:80",
+		OldState: "up",
+		NewState: "down",
+	}}
+	if !cmp.Equal(want, got, cmpopts.IgnoreFields(nginxhealthz.Transition{}, "Time")) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestStateTracker_ReportsHostTransitions(t *testing.T) {
+	t.Parallel()
+
+	st := nginxhealthz.NewStateTracker()
+	st.ObserveHost("bar.example.org", nginxhealthz.Stats{Total: 2, Up: 2})
+	if got := st.ObserveHost("bar.example.org", nginxhealthz.Stats{Total: 2, Up: 2}); len(got) != 0 {
+		t.Errorf("want no transition without change, got %+v", got)
+	}
+
+	got := st.ObserveHost("bar.example.org", nginxhealthz.Stats{Total: 2, Up: 1, Down: 1})
+	if len(got) != 1 || got[0].OldState != nginxhealthz.HostHealthy || got[0].NewState != nginxhealthz.HostUnhealthy {
+		t.Errorf("want healthy -> unhealthy transition, got %+v", got)
+	}
+}
+
+func TestStateTracker_ReportsHostWithEmptyUpstreamAsUnhealthy(t *testing.T) {
+	t.Parallel()
+
+	st := nginxhealthz.NewStateTracker()
+	st.ObserveHost("bar.example.org", nginxhealthz.Stats{Total: 2, Up: 2})
+	got := st.ObserveHost("bar.example.org", nginxhealthz.Stats{Total: 2, Up: 2, Empty: 1})
+	if len(got) != 1 || got[0].OldState != nginxhealthz.HostHealthy || got[0].NewState != nginxhealthz.HostUnhealthy {
+		t.Errorf("want healthy -> unhealthy transition, got %+v", got)
+	}
+}
+
+func TestStateTracker_UsesConfiguredHealthPolicy(t *testing.T) {
+	t.Parallel()
+
+	st := nginxhealthz.NewStateTracker(nginxhealthz.WithTrackerHealthPolicy(nginxhealthz.HealthPolicy{MinUp: 1}))
+	st.ObserveHost("bar.example.org", nginxhealthz.Stats{Total: 2, Up: 2})
+	if got := st.ObserveHost("bar.example.org", nginxhealthz.Stats{Total: 2, Up: 1, Down: 1}); len(got) != 0 {
+		t.Errorf("want host healthy with one peer up, got %+v", got)
+	}
+	got := st.ObserveHost("bar.example.org", nginxhealthz.Stats{Total: 2, Down: 2})
+	if len(got) != 1 || got[0].NewState != nginxhealthz.HostUnhealthy {
+		t.Errorf("want healthy -> unhealthy transition, got %+v", got)
+	}
+}
@@ -0,0 +1,89 @@
+// Package transport provides an http.RoundTripper that consults cached
+// NGINX upstream health before sending a request.
+//
+// The RoundTripper is meant to sit in front of clients calling services
+// load balanced by NGINX. It never queries the NGINX API itself; it reads
+// the stats last collected by a HealthSource such as nginxhealthz.Poller,
+// so it adds no latency to the wrapped requests. Requests to hosts the
+// source knows nothing about are always sent.
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+// HealthHeader is set on requests to unhealthy hosts when the
+// RoundTripper is configured with WithHeaderOnly.
+const HealthHeader = "X-Nginx-Healthz"
+
+// ErrHostUnhealthy is returned for requests to hosts reported unhealthy.
+var ErrHostUnhealthy = errors.New("host unhealthy")
+
+// HealthSource provides the latest known stats for a host.
+// nginxhealthz.Poller implements it.
+type HealthSource interface {
+	Latest(host string) (nginxhealthz.Stats, time.Time, bool)
+}
+
+type option func(*RoundTripper)
+
+// WithHeaderOnly makes the RoundTripper send requests to unhealthy hosts
+// with the HealthHeader set to "unhealthy" instead of failing them.
+func WithHeaderOnly() option {
+	return func(rt *RoundTripper) {
+		rt.headerOnly = true
+	}
+}
+
+// WithHealthFunc sets the function deciding whether a host is healthy.
+// By default a host is healthy when at least one of its peers is up.
+func WithHealthFunc(fn func(nginxhealthz.Stats) bool) option {
+	return func(rt *RoundTripper) {
+		rt.healthy = fn
+	}
+}
+
+// RoundTripper fails fast, or marks, requests to unhealthy hosts.
+type RoundTripper struct {
+	next       http.RoundTripper
+	source     HealthSource
+	headerOnly bool
+	healthy    func(nginxhealthz.Stats) bool
+}
+
+// New wraps next, which defaults to http.DefaultTransport when nil.
+func New(next http.RoundTripper, source HealthSource, opts ...option) *RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	rt := &RoundTripper{
+		next:    next,
+		source:  source,
+		healthy: func(s nginxhealthz.Stats) bool { return s.Up > 0 },
+	}
+	for _, opt := range opts {
+		opt(rt)
+	}
+	return rt
+}
+
+// RoundTrip implements http.RoundTripper.
+func (rt *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+	stats, _, ok := rt.source.Latest(host)
+	if !ok || rt.healthy(stats) {
+		return rt.next.RoundTrip(req)
+	}
+	if !rt.headerOnly {
+		return nil, fmt.Errorf("%w: %s (%d of %d peers up)", ErrHostUnhealthy, host, stats.Up, stats.Total)
+	}
+	// A RoundTripper must not modify the caller's request.
+	req = req.Clone(req.Context())
+	req.Header.Set(HealthHeader, "unhealthy")
+	return rt.next.RoundTrip(req)
+}
@@ -0,0 +1,82 @@
+package transport_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+	"github.com/qba73/nginx-healthz/transport"
+)
+
+type staticSource map[string]nginxhealthz.Stats
+
+func (s staticSource) Latest(host string) (nginxhealthz.Stats, time.Time, bool) {
+	stats, ok := s[host]
+	return stats, time.Now(), ok
+}
+
+func TestRoundTripper_FailsFastForUnhealthyHost(t *testing.T) {
+	t.Parallel()
+
+	var called bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer ts.Close()
+
+	src := staticSource{"127.0.0.1": {Total: 2, Up: 0, Down: 2}}
+	c := &http.Client{Transport: transport.New(nil, src)}
+
+	_, err := c.Get(ts.URL)
+	if !errors.Is(err, transport.ErrHostUnhealthy) {
+		t.Fatalf("want ErrHostUnhealthy, got %v", err)
+	}
+	if called {
+		t.Error("request sent to unhealthy host")
+	}
+}
+
+func TestRoundTripper_SendsRequestForHealthyOrUnknownHost(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	tests := map[string]staticSource{
+		"healthy": {"127.0.0.1": {Total: 2, Up: 1, Down: 1}},
+		"unknown": {},
+	}
+	for name, src := range tests {
+		c := &http.Client{Transport: transport.New(nil, src)}
+		resp, err := c.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestRoundTripper_AddsHeaderForUnhealthyHostInHeaderOnlyMode(t *testing.T) {
+	t.Parallel()
+
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(transport.HealthHeader)
+	}))
+	defer ts.Close()
+
+	src := staticSource{"127.0.0.1": {Total: 2, Up: 0, Down: 2}}
+	c := &http.Client{Transport: transport.New(nil, src, transport.WithHeaderOnly())}
+
+	resp, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got != "unhealthy" {
+		t.Errorf("want header %q, got %q", "unhealthy", got)
+	}
+}
@@ -0,0 +1,76 @@
+package nginxhealthz
+
+import (
+	"context"
+	"fmt"
+)
+
+// WarningCode identifies the kind of a Warning.
+type WarningCode string
+
+const (
+	// WarningZombies is reported when the upstream has
+	// removed peers still processing requests.
+	WarningZombies WarningCode = "zombies"
+	// WarningUnknownPeerState is reported for a peer in a
+	// state this package does not recognise.
+	WarningUnknownPeerState WarningCode = "unknown_peer_state"
+	// WarningZoneNaming is reported when the zone name does not follow
+	// the "hostname-upstream" convention used to map upstreams to hosts.
+	WarningZoneNaming WarningCode = "zone_naming"
+)
+
+// Warning describes a condition that does not make a call fail
+// but may be worth surfacing.
+type Warning struct {
+	Code    WarningCode `json:"code"`
+	Message string      `json:"message"`
+}
+
+var knownPeerStates = map[string]bool{
+	"up":        true,
+	"down":      true,
+	"unavail":   true,
+	"checking":  true,
+	"draining":  true,
+	"unhealthy": true,
+}
+
+// GetStatsWithWarningsFor works like GetStatsFor and also returns
+// the non-fatal warnings found in the upstream.
+func (c *Client) GetStatsWithWarningsFor(ctx context.Context, upstream string) (Stats, []Warning, error) {
+	res, err := c.getUpstream(ctx, upstream)
+	if err != nil {
+		return Stats{}, nil, err
+	}
+	stats, err := c.calculateStatsFor(upstream, res)
+	if err != nil {
+		return Stats{}, nil, err
+	}
+	return stats, c.warningsFor(upstream, res), nil
+}
+
+func (c *Client) warningsFor(upstream string, res responseUpstream) []Warning {
+	var warnings []Warning
+	if res.Zombies > 0 {
+		warnings = append(warnings, Warning{
+			Code:    WarningZombies,
+			Message: fmt.Sprintf("upstream %s has %d zombie peers", upstream, res.Zombies),
+		})
+	}
+	for _, p := range res.Peers {
+		if !knownPeerStates[p.State] {
+			warnings = append(warnings, Warning{
+				Code:    WarningUnknownPeerState,
+				Message: fmt.Sprintf("peer %s is in unknown state %q", p.Server, p.State),
+			})
+		}
+	}
+	if _, ok := c.hostFromZone(res.Zone, upstream); !ok {
+		warnings = append(warnings, Warning{
+			Code:    WarningZoneNaming,
+			Message: fmt.Sprintf("zone %q does not include a hostname", res.Zone),
+		})
+	}
+	return warnings
+}
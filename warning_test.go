@@ -0,0 +1,69 @@
+package nginxhealthz_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+func TestGetStatsWithWarningsFor_ReportsNonFatalConditions(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(`{
+		"zone": "legacy",
+		"zombies": 2,
+		"peers": [
+			{"server": "10.0.0.1:80", "state": "up"},
+			{"server": "10.0.0.2:80", "state": "bogus"}
+		]
+	}`, "/api/8/http/upstreams/legacy", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, got, err := c.GetStatsWithWarningsFor(context.Background(), "legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != 2 {
+		t.Errorf("want stats for 2 peers, got %+v", stats)
+	}
+	var codes []nginxhealthz.WarningCode
+	for _, w := range got {
+		codes = append(codes, w.Code)
+	}
+	want := []nginxhealthz.WarningCode{
+		nginxhealthz.WarningZombies,
+		nginxhealthz.WarningUnknownPeerState,
+		nginxhealthz.WarningZoneNaming,
+	}
+	if !cmp.Equal(want, codes) {
+		t.Error(cmp.Diff(want, codes))
+	}
+}
+
+func TestGetStatsWithWarningsFor_ReturnsNoWarningsForHealthyUpstream(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServerWithPathValidator(validResponseUpstreamHGbackend, "/api/8/http/upstreams/hg-backend", t)
+	defer ts.Close()
+
+	c, err := nginxhealthz.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, got, err := c.GetStatsWithWarningsFor(context.Background(), "hg-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("want no warnings, got %+v", got)
+	}
+}
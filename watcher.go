@@ -0,0 +1,75 @@
+package nginxhealthz
+
+import (
+	"sort"
+	"sync"
+)
+
+// UpstreamChange describes an upstream whose stats changed.
+type UpstreamChange struct {
+	Name string `json:"name"`
+	Old  Stats  `json:"old"`
+	New  Stats  `json:"new"`
+}
+
+// UpstreamDiff lists the upstreams added, removed and changed between
+// two sets of stats, each sorted by upstream name.
+type UpstreamDiff struct {
+	Added   []string         `json:"added,omitempty"`
+	Removed []string         `json:"removed,omitempty"`
+	Changed []UpstreamChange `json:"changed,omitempty"`
+}
+
+// Empty reports whether nothing changed.
+func (d UpstreamDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Watcher remembers the stats of every upstream, as returned by
+// GetAllStats, and reports what changed between updates. It does not
+// query NGINX itself. It is safe for concurrent use.
+type Watcher struct {
+	mu   sync.Mutex
+	last map[string]Stats
+}
+
+// NewWatcher returns a Watcher that has seen no upstreams.
+func NewWatcher() *Watcher {
+	return &Watcher{last: make(map[string]Stats)}
+}
+
+// Update records the current stats and returns the difference from the
+// previous update. On the first update all upstreams are reported added.
+func (w *Watcher) Update(current map[string]Stats) UpstreamDiff {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	diff := diffUpstreams(w.last, current)
+	w.last = make(map[string]Stats, len(current))
+	for name, s := range current {
+		w.last[name] = s
+	}
+	return diff
+}
+
+func diffUpstreams(old, cur map[string]Stats) UpstreamDiff {
+	var d UpstreamDiff
+	for name, s := range cur {
+		prev, ok := old[name]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, name)
+		case prev != s:
+			d.Changed = append(d.Changed, UpstreamChange{Name: name, Old: prev, New: s})
+		}
+	}
+	for name := range old {
+		if _, ok := cur[name]; !ok {
+			d.Removed = append(d.Removed, name)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Slice(d.Changed, func(i, j int) bool { return d.Changed[i].Name < d.Changed[j].Name })
+	return d
+}
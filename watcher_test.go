@@ -0,0 +1,56 @@
+package nginxhealthz_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+func TestWatcher_ReportsAddedRemovedAndChangedUpstreams(t *testing.T) {
+	t.Parallel()
+
+	w := nginxhealthz.NewWatcher()
+	first := w.Update(map[string]nginxhealthz.Stats{
+		"hg-backend":  {Total: 2, Up: 2},
+		"lxr-backend": {Total: 2, Up: 2},
+	})
+	want := nginxhealthz.UpstreamDiff{Added: []string{"hg-backend", "lxr-backend"}}
+	if !cmp.Equal(want, first) {
+		t.Error(cmp.Diff(want, first))
+	}
+
+	got := w.Update(map[string]nginxhealthz.Stats{
+		"hg-backend":   {Total: 2, Up: 1, Down: 1},
+		"demo-backend": {Total: 1, Up: 1},
+	})
+	want = nginxhealthz.UpstreamDiff{
+		Added:   []string{"demo-backend"},
+		Removed: []string{"lxr-backend"},
+		Changed: []nginxhealthz.UpstreamChange{{
+			Name: "hg-backend",
+			Old:  nginxhealthz.Stats{Total: 2, Up: 2},
+			New:  nginxhealthz.Stats{Total: 2, Up: 1, Down: 1},
+		}},
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+
+	if _, err := json.Marshal(got); err != nil {
+		t.Errorf("want diff to be JSON-serializable, got %v", err)
+	}
+}
+
+func TestWatcher_ReportsEmptyDiffWithoutChanges(t *testing.T) {
+	t.Parallel()
+
+	w := nginxhealthz.NewWatcher()
+	stats := map[string]nginxhealthz.Stats{"hg-backend": {Total: 2, Up: 2}}
+	w.Update(stats)
+	if got := w.Update(stats); !got.Empty() {
+		t.Errorf("want empty diff, got %+v", got)
+	}
+}
@@ -0,0 +1,176 @@
+package nginxhealthz
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type webhookOption func(*Webhook) error
+
+// WithWebhookHTTPClient sets the HTTP client used to deliver notifications.
+func WithWebhookHTTPClient(h *http.Client) webhookOption {
+	return func(w *Webhook) error {
+		if h == nil {
+			return errors.New("nil http client")
+		}
+		w.httpClient = h
+		return nil
+	}
+}
+
+// WithWebhookRetries sets how many times a failed delivery is retried and
+// the delay before the first retry, doubled after every attempt.
+func WithWebhookRetries(n int, baseDelay time.Duration) webhookOption {
+	return func(w *Webhook) error {
+		if n < 0 {
+			return fmt.Errorf("invalid number of retries: %d", n)
+		}
+		if baseDelay < 0 {
+			return fmt.Errorf("invalid retry delay: %v", baseDelay)
+		}
+		w.retries = n
+		w.baseDelay = baseDelay
+		return nil
+	}
+}
+
+// WithWebhookLogger sets the logger reporting failed deliveries.
+func WithWebhookLogger(l *slog.Logger) webhookOption {
+	return func(w *Webhook) error {
+		if l == nil {
+			return errors.New("nil logger")
+		}
+		w.logger = l
+		return nil
+	}
+}
+
+// Webhook POSTs every Transition as JSON to a URL. Notify queues
+// transitions without blocking; Run delivers them, retrying connection
+// errors and 5xx responses.
+type Webhook struct {
+	url        string
+	httpClient *http.Client
+	retries    int
+	baseDelay  time.Duration
+	logger     *slog.Logger
+	queue      chan Transition
+}
+
+// NewWebhook returns a Webhook delivering transitions to url.
+// It fails when url is not an absolute http or https URL
+// or any of the options is invalid.
+func NewWebhook(url string, opts ...webhookOption) (*Webhook, error) {
+	if err := validateWebhookURL(url); err != nil {
+		return nil, err
+	}
+	w := &Webhook{
+		url:        url,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		retries:    3,
+		baseDelay:  time.Second,
+		logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+		queue:      make(chan Transition, 100),
+	}
+	for _, opt := range opts {
+		if err := opt(w); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func validateWebhookURL(rawURL string) error {
+	if rawURL == "" {
+		return errors.New("invalid webhook URL: empty")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL %q: %w", rawURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid webhook URL %q: want http or https scheme", rawURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q: missing host", rawURL)
+	}
+	return nil
+}
+
+// Notify queues the transitions for delivery. It never blocks: when the
+// queue is full, transitions are dropped and the number dropped is returned.
+func (w *Webhook) Notify(transitions ...Transition) int {
+	dropped := 0
+	for _, t := range transitions {
+		select {
+		case w.queue <- t:
+		default:
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		w.logger.Warn("webhook queue full, dropping transitions", "dropped", dropped)
+	}
+	return dropped
+}
+
+// Run delivers queued transitions until ctx is done.
+func (w *Webhook) Run(ctx context.Context) {
+	for {
+		select {
+		case t := <-w.queue:
+			if err := w.deliver(ctx, t); err != nil {
+				w.logger.Warn("delivering webhook", "host", t.Host, "upstream", t.Upstream, "peer", t.Peer, "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *Webhook) deliver(ctx context.Context, t Transition) error {
+	body, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("encoding payload: %w", err)
+	}
+	delay := w.baseDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(ctx, body)
+		if err == nil || !retry || attempt >= w.retries {
+			return err
+		}
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// post sends the payload once and reports whether a failure is transient.
+func (w *Webhook) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	return resp.StatusCode >= 500, fmt.Errorf("got response code: %v", resp.StatusCode)
+}
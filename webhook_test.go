@@ -0,0 +1,140 @@
+package nginxhealthz_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	nginxhealthz "github.com/qba73/nginx-healthz"
+)
+
+func TestWebhook_RetriesTransientFailures(t *testing.T) {
+	t.Parallel()
+
+	var attempts int64
+	delivered := make(chan nginxhealthz.Transition, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var tr nginxhealthz.Transition
+		if err := json.NewDecoder(r.Body).Decode(&tr); err != nil {
+			t.Error(err)
+		}
+		delivered <- tr
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wh, err := nginxhealthz.NewWebhook(ts.URL, nginxhealthz.WithWebhookRetries(3, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go wh.Run(ctx)
+
+	want := nginxhealthz.Transition{
+		Host:     "bar.example.org",
+		Upstream: "hg-backend",
+		Peer:     "10.0.0.2:80",
+		OldState: "up",
+		NewState: "down",
+		Time:     time.Date(2022, 10, 17, 20, 38, 40, 0, time.UTC),
+	}
+	if dropped := wh.Notify(want); dropped != 0 {
+		t.Fatalf("want no dropped transitions, got %d", dropped)
+	}
+
+	select {
+	case got := <-delivered:
+		if got != want {
+			t.Errorf("want %+v, got %+v", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("transition not delivered")
+	}
+	if n := atomic.LoadInt64(&attempts); n != 3 {
+		t.Errorf("want 3 attempts, got %d", n)
+	}
+}
+
+func TestWebhook_NotifyDoesNotBlockWhenQueueIsFull(t *testing.T) {
+	t.Parallel()
+
+	wh, err := nginxhealthz.NewWebhook("http://localhost:9001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan int)
+	go func() {
+		dropped := 0
+		for i := 0; i < 200; i++ {
+			dropped += wh.Notify(nginxhealthz.Transition{Host: "bar.example.org"})
+		}
+		done <- dropped
+	}()
+
+	select {
+	case dropped := <-done:
+		if dropped == 0 {
+			t.Error("want transitions dropped when nobody delivers them")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Notify blocked")
+	}
+}
+
+func TestNewWebhook_FailsOnInvalidOptions(t *testing.T) {
+	t.Parallel()
+
+	const url = "http://localhost:9001"
+	for name, newWebhook := range map[string]func() (*nginxhealthz.Webhook, error){
+		"nil http client": func() (*nginxhealthz.Webhook, error) {
+			return nginxhealthz.NewWebhook(url, nginxhealthz.WithWebhookHTTPClient(nil))
+		},
+		"negative retries": func() (*nginxhealthz.Webhook, error) {
+			return nginxhealthz.NewWebhook(url, nginxhealthz.WithWebhookRetries(-1, time.Millisecond))
+		},
+		"negative retry delay": func() (*nginxhealthz.Webhook, error) {
+			return nginxhealthz.NewWebhook(url, nginxhealthz.WithWebhookRetries(3, -time.Millisecond))
+		},
+		"nil logger": func() (*nginxhealthz.Webhook, error) {
+			return nginxhealthz.NewWebhook(url, nginxhealthz.WithWebhookLogger(nil))
+		},
+	} {
+		if _, err := newWebhook(); err == nil {
+			t.Errorf("%s: want error, got nil", name)
+		}
+	}
+}
+
+func TestNewWebhook_FailsOnInvalidURL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		url  string
+	}{
+		{name: "empty", url: ""},
+		{name: "relative", url: "/hooks/nginx"},
+		{name: "without scheme", url: "hooks.example.org/nginx"},
+		{name: "unsupported scheme", url: "ftp://hooks.example.org/nginx"},
+		{name: "without host", url: "http:///nginx"},
+		{name: "malformed", url: "http://hooks.example.org:port"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if _, err := nginxhealthz.NewWebhook(tt.url); err == nil {
+				t.Errorf("want error for URL %q, got nil", tt.url)
+			}
+		})
+	}
+}